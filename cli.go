@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	dockerVolumeS3 "github.com/AVENTER-UG/docker-volume-s3/lib"
+	util "github.com/AVENTER-UG/util/util"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+)
+
+// commands are the CLI subcommands
+var commands = map[string]func(d *dockerVolumeS3.S3fsDriver, args []string) error{
+	"serve":    serve,
+	"ls":       list,
+	"inspect":  inspect,
+	"create":   create,
+	"rm":       remove,
+	"mount":    mount,
+	"umount":   unmount,
+	"lock":     lock,
+	"unlock":   unlock,
+	"snapshot": snapshot,
+	"export":   export,
+	"doctor":   doctor,
+}
+
+// usages are the arguments of the CLI subcommands
+var usages = map[string]string{
+	"serve":    "serve",
+	"ls":       "ls",
+	"inspect":  "inspect <volume>",
+	"create":   "create <volume> [-o key=value]...",
+	"rm":       "rm [-f] <volume>",
+	"mount":    "mount <volume> [id]",
+	"umount":   "umount <volume> [id]",
+	"lock":     "lock <bucket> <object>",
+	"unlock":   "unlock [-f] <bucket> <object>",
+	"snapshot": "snapshot <volume> [name]",
+	"export":   "export <volume>",
+	"doctor":   "doctor",
+}
+
+// usage prints the CLI usage
+func usage() {
+	var names []string
+	for name := range usages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: docker-volume-s3 <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", usages[name])
+	}
+}
+
+// usageError reports the usage of a command
+func usageError(name string) error {
+	return fmt.Errorf("usage: docker-volume-s3 %s", usages[name])
+}
+
+// volumeArg gets the volume argument of a command
+func volumeArg(args []string, name string) (string, error) {
+	if len(args) < 1 {
+		return "", usageError(name)
+	}
+	return args[0], nil
+}
+
+// serve serves the docker volume plugin API
+func serve(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	socket := util.Getenv("S3_CONF_SOCKET", socketAddress)
+	go func() {
+		err := d.ServeAdmin()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}()
+	go func() {
+		err := d.ServeMetrics()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}()
+	go d.ScanUsage()
+	go d.RefreshMountMarkers()
+	h := volume.NewHandler(d.WithMetrics())
+	logrus.Infof("plugin(s3) version(%s) started with log level(%s) attending socket(%s)", os.Getenv("PLUGIN_VERSION"), os.Getenv("LOG_LEVEL"), socket)
+	return h.ServeUnix(socket, 0)
+}
+
+// list lists the volumes
+func list(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	resp, err := d.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMOUNTPOINT\tCREATED")
+	for _, v := range resp.Volumes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Name, v.Mountpoint, v.CreatedAt)
+	}
+	return w.Flush()
+}
+
+// inspect prints a volume as json
+func inspect(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	name, err := volumeArg(args, "inspect")
+	if err != nil {
+		return err
+	}
+	resp, err := d.Get(&volume.GetRequest{Name: name})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(resp.Volume)
+}
+
+// create creates a volume
+func create(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	name, err := volumeArg(args, "create")
+	if err != nil {
+		return err
+	}
+	options := make(map[string]string)
+	for i := 1; i < len(args); i++ {
+		if args[i] != "-o" || i+1 == len(args) {
+			return usageError("create")
+		}
+		i++
+		infos := strings.SplitN(args[i], "=", 2)
+		if len(infos) != 2 {
+			return fmt.Errorf("invalid option: %s", args[i])
+		}
+		options[infos[0]] = infos[1]
+	}
+	return d.Create(&volume.CreateRequest{Name: name, Options: options})
+}
+
+// remove removes a volume
+func remove(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	if len(args) == 2 && args[0] == "-f" {
+		return d.ForceRemove(args[1])
+	}
+	name, err := volumeArg(args, "rm")
+	if err != nil {
+		return err
+	}
+	return d.Remove(&volume.RemoveRequest{Name: name})
+}
+
+// mount mounts a volume and prints its mount point
+func mount(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	name, err := volumeArg(args, "mount")
+	if err != nil {
+		return err
+	}
+	id := "cli"
+	if len(args) > 1 {
+		id = args[1]
+	}
+	resp, err := d.Mount(&volume.MountRequest{Name: name, ID: id})
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Mountpoint)
+	return nil
+}
+
+// unmount unmounts a volume
+func unmount(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	name, err := volumeArg(args, "umount")
+	if err != nil {
+		return err
+	}
+	id := "cli"
+	if len(args) > 1 {
+		id = args[1]
+	}
+	return d.Unmount(&volume.UnmountRequest{Name: name, ID: id})
+}
+
+// lock takes a lock on an object
+func lock(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	if len(args) != 2 {
+		return usageError("lock")
+	}
+	return d.Lock(args[0], args[1])
+}
+
+// unlock releases or breaks a lock on an object
+func unlock(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	if len(args) == 3 && args[0] == "-f" {
+		return d.BreakLock(args[1], args[2])
+	}
+	if len(args) != 2 {
+		return usageError("unlock")
+	}
+	return d.UnLock(args[0], args[1])
+}
+
+// snapshot takes a snapshot of a volume
+func snapshot(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	name, err := volumeArg(args, "snapshot")
+	if err != nil {
+		return err
+	}
+	snapshot := ""
+	if len(args) > 1 {
+		snapshot = args[1]
+	}
+	snapshot, err = d.Snapshot(name, snapshot)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s/%s\n", name, snapshot)
+	return nil
+}
+
+// export writes a volume as a tar archive to stdout
+func export(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	name, err := volumeArg(args, "export")
+	if err != nil {
+		return err
+	}
+	return d.Export(name, os.Stdout)
+}
+
+// doctor runs the diagnostics
+func doctor(d *dockerVolumeS3.S3fsDriver, args []string) error {
+	return dockerVolumeS3.Doctor(os.Stdout)
+}
@@ -0,0 +1,44 @@
+package dockerVolumeS3
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/s3utils"
+)
+
+const (
+	addressingAuto    = "auto"
+	addressingPath    = "path"
+	addressingVirtual = "virtual"
+)
+
+// bucketLookup gets the minio bucket lookup of an addressing style
+func bucketLookup(addressing string) (minio.BucketLookupType, error) {
+	switch addressing {
+	case addressingAuto:
+		return minio.BucketLookupAuto, nil
+	case addressingPath:
+		return minio.BucketLookupPath, nil
+	case addressingVirtual:
+		return minio.BucketLookupDNS, nil
+	}
+	return minio.BucketLookupAuto, fmt.Errorf("unknown addressing %s: expected auto, path or virtual", addressing)
+}
+
+// pathStyle tells if requests to a bucket use path style addressing, deciding
+// like the minio client in auto mode
+func (d *S3fsDriver) pathStyle(bucket string) bool {
+	switch d.conf["addressing"] {
+	case addressingPath:
+		return true
+	case addressingVirtual:
+		return false
+	}
+	u, err := url.Parse(d.conf["endpoint"])
+	if err != nil {
+		return true
+	}
+	return !s3utils.IsVirtualHostSupported(*u, bucket)
+}
@@ -0,0 +1,302 @@
+package dockerVolumeS3
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	redacted = "*****"
+)
+
+// MountState is an active mount of this host
+type MountState struct {
+	Volume     string               `json:"volume"`
+	Mountpoint string               `json:"mountpoint"`
+	Count      int                  `json:"count"`
+	Callers    map[string]time.Time `json:"callers"`
+	Mounted    bool                 `json:"mounted"`
+}
+
+// ProcessState is a running s3fs process
+type ProcessState struct {
+	PID        int    `json:"pid"`
+	State      string `json:"state"`
+	Bucket     string `json:"bucket"`
+	Mountpoint string `json:"mountpoint"`
+}
+
+// LockState is a lock of the configuration bucket
+type LockState struct {
+	Object string    `json:"object"`
+	Owner  string    `json:"owner"`
+	Since  time.Time `json:"since"`
+}
+
+// addCaller records the caller ID of a mount, mountsLock must be held
+func (d *S3fsDriver) addCaller(name string, id string) {
+	if _, ok := d.callers[name]; !ok {
+		d.callers[name] = make(map[string]time.Time)
+	}
+	d.callers[name][id] = time.Now()
+}
+
+// Mounts gets the active mounts of this host
+func (d *S3fsDriver) Mounts() []MountState {
+	d.mountsLock.Lock()
+	defer d.mountsLock.Unlock()
+	var mounts []MountState
+	for name, count := range d.mounts {
+		callers := make(map[string]time.Time)
+		for id, t := range d.callers[name] {
+			callers[id] = t
+		}
+		path := fmt.Sprintf("%s/%s", d.conf["rootmount"], name)
+		mounts = append(mounts, MountState{Volume: name, Mountpoint: path, Count: count, Callers: callers, Mounted: isMounted(path)})
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Volume < mounts[j].Volume })
+	return mounts
+}
+
+// Processes lists the s3fs processes of this host
+func (d *S3fsDriver) Processes() []ProcessState {
+	var processes []ProcessState
+	pids, _ := filepath.Glob("/proc/[0-9]*")
+	for _, p := range pids {
+		cmdline, err := ioutil.ReadFile(filepath.Join(p, "cmdline"))
+		if err != nil {
+			continue
+		}
+		args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+		if len(args) < 3 || filepath.Base(args[0]) != "s3fs" {
+			continue
+		}
+		pid, _ := strconv.Atoi(filepath.Base(p))
+		state := ""
+		stat, err := ioutil.ReadFile(filepath.Join(p, "stat"))
+		if err == nil {
+			// the state follows the command name in parenthesis
+			fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+			if len(fields) > 0 {
+				state = fields[0]
+			}
+		}
+		processes = append(processes, ProcessState{PID: pid, State: state, Bucket: args[1], Mountpoint: args[2]})
+	}
+	return processes
+}
+
+// Locks lists the locks of the configuration bucket
+func (d *S3fsDriver) Locks() ([]LockState, error) {
+	var locks []LockState
+	err := d.listLocks("", &locks)
+	if err != nil {
+		return nil, err
+	}
+	return locks, nil
+}
+
+// listLocks lists the locks below a prefix of the configuration bucket,
+// skipping the prefixes holding the data of the plugin
+func (d *S3fsDriver) listLocks(prefix string, locks *[]LockState) error {
+	for object := range d.s3client.ListObjects(d.conf["configbucket"], prefix, false, nil) {
+		if object.Err != nil {
+			return fmt.Errorf("could not list locks: %s", object.Err)
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			switch object.Key {
+			case mountsPrefix, snapshotsPrefix, keysPrefix, clonesPrefix, d.conf["archive_prefix"]:
+				continue
+			}
+			err := d.listLocks(object.Key, locks)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !strings.HasSuffix(object.Key, lockExt) {
+			continue
+		}
+		owner := bytes.Buffer{}
+		err := d.readObject(d.conf["configbucket"], object.Key, func(r io.Reader) error {
+			_, err := owner.ReadFrom(r)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("could not read lock %s: %s", object.Key, err)
+		}
+		*locks = append(*locks, LockState{Object: strings.TrimSuffix(object.Key, lockExt), Owner: owner.String(), Since: object.LastModified})
+	}
+	return nil
+}
+
+// RedactedConfig gets the configuration without secrets
+func (d *S3fsDriver) RedactedConfig() map[string]string {
+	return redactOptions(d.conf)
+}
+
+// redactOptions hides the values of secret options
+func redactOptions(options map[string]string) map[string]string {
+	res := make(map[string]string)
+	for k, v := range options {
+		lower := strings.ToLower(k)
+		if len(v) > 0 && (strings.Contains(lower, "secret") || strings.Contains(lower, "token") || strings.Contains(lower, "password") || strings.HasSuffix(lower, "key")) {
+			v = redacted
+		} else if u, err := url.Parse(v); err == nil && u.User != nil {
+			// proxy and seed urls may hold credentials
+			v = u.Redacted()
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// ForceUnmount unmounts a volume whatever uses it and resets its reference count
+func (d *S3fsDriver) ForceUnmount(name string) error {
+	if len(name) == 0 || strings.Contains(name, "/") || name == "." || name == ".." {
+		return fmt.Errorf("invalid volume name %q", name)
+	}
+	vol, err := d.getVolume(name)
+	if err != nil {
+		return err
+	}
+	d.mountsLock.Lock()
+	defer d.mountsLock.Unlock()
+	if _, ok := d.mounts[name]; !ok && vol == nil {
+		return fmt.Errorf("unknown volume %s", name)
+	}
+	path := fmt.Sprintf("%s/%s", d.conf["rootmount"], name)
+	if !isMounted(path) {
+		return fmt.Errorf("volume %s is not mounted", name)
+	}
+	log.WithField("command", "admin").WithField("method", "unmount").Warnf("forcing unmount of volume %s", name)
+	err = d.unmountWith(path, []string{unmountForce, unmountLazy})
+	if err != nil {
+		return err
+	}
+	delete(d.mounts, name)
+	delete(d.callers, name)
+	d.forgetHealth(path)
+	err = d.unmarkMounted(name)
+	if err != nil {
+		log.WithField("command", "admin").WithField("method", "unmount").Warnf("could not unmark volume %s as mounted: %s", name, err)
+	}
+	return nil
+}
+
+// adminHandler routes the admin API
+func (d *S3fsDriver) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mounts", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.Mounts(), nil)
+	})
+	mux.HandleFunc("/processes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.Processes(), nil)
+	})
+	mux.HandleFunc("/locks", func(w http.ResponseWriter, r *http.Request) {
+		locks, err := d.Locks()
+		writeJSON(w, locks, err)
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.RedactedConfig(), nil)
+	})
+	mux.HandleFunc("/unmount", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		err := d.ForceUnmount(r.URL.Query().Get("volume"))
+		writeJSON(w, map[string]string{"status": "unmounted"}, err)
+	})
+	mux.HandleFunc("/breaklock", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		bucket := r.URL.Query().Get("bucket")
+		if len(bucket) == 0 {
+			bucket = d.conf["configbucket"]
+		}
+		err := d.BreakLock(bucket, r.URL.Query().Get("object"))
+		writeJSON(w, map[string]string{"status": "unlocked"}, err)
+	})
+	return mux
+}
+
+// writeJSON writes a json response or an error
+func writeJSON(w http.ResponseWriter, v interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		v = map[string]string{"error": err.Error()}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// requireToken checks the bearer token of the admin requests
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminListener opens the listener of an unix:// or tcp:// address
+func adminListener(address string) (net.Listener, bool, error) {
+	if strings.HasPrefix(address, "unix://") {
+		path := strings.TrimPrefix(address, "unix://")
+		os.Remove(path)
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, false, err
+		}
+		return l, false, os.Chmod(path, 0600)
+	}
+	l, err := net.Listen("tcp", strings.TrimPrefix(address, "tcp://"))
+	return l, true, err
+}
+
+// ServeAdmin serves the admin API on S3_CONF_ADMINADDRESS, TCP listeners
+// require the S3_CONF_ADMINTOKEN bearer token
+func (d *S3fsDriver) ServeAdmin() error {
+	address := d.conf["adminaddress"]
+	if len(address) == 0 {
+		return nil
+	}
+	l, tcp, err := adminListener(address)
+	if err != nil {
+		log.WithField("command", "admin").Errorf("could not listen on %s: %s", address, err)
+		return fmt.Errorf("could not listen on %s: %s", address, err)
+	}
+	handler := d.adminHandler()
+	if len(d.conf["admintoken"]) > 0 {
+		handler = requireToken(d.conf["admintoken"], handler)
+	} else if tcp {
+		l.Close()
+		log.WithField("command", "admin").Errorf("admin token required to listen on %s", address)
+		return fmt.Errorf("admin token required to listen on %s", address)
+	}
+	log.WithField("command", "admin").Infof("admin api listening on %s", address)
+	return http.Serve(l, handler)
+}
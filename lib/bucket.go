@@ -0,0 +1,279 @@
+package dockerVolumeS3
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/tags"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	tagOptionPrefix = "tag."
+	encryptSSES3    = "sse-s3"
+	encryptSSEKMS   = "sse-kms"
+	policyNone      = "none"
+	policyDownload  = "download"
+	policyUpload    = "upload"
+	policyPublic    = "public"
+	bucketLifecycle = `<LifecycleConfiguration><Rule><ID>docker-volume-s3</ID><Filter><Prefix></Prefix></Filter><Status>Enabled</Status>%s</Rule></LifecycleConfiguration>`
+	bucketStatement = `{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["%s"],"Resource":["arn:aws:s3:::%s"]}`
+)
+
+// policyStatement allows anonymous actions on a bucket or its objects
+type policyStatement struct {
+	actions []string
+	objects bool
+}
+
+// policyStatements are the statements of the canned bucket policies
+var policyStatements = map[string][]policyStatement{
+	policyDownload: {
+		{actions: []string{"s3:GetBucketLocation", "s3:ListBucket"}},
+		{actions: []string{"s3:GetObject"}, objects: true},
+	},
+	policyUpload: {
+		{actions: []string{"s3:GetBucketLocation", "s3:ListBucketMultipartUploads"}},
+		{actions: []string{"s3:AbortMultipartUpload", "s3:DeleteObject", "s3:ListMultipartUploadParts", "s3:PutObject"}, objects: true},
+	},
+}
+
+// parseObjectLock parses an object lock option: true, or a default retention
+// like governance:30d or compliance:1y
+func parseObjectLock(value string) (*minio.RetentionMode, *uint, *minio.ValidityUnit, error) {
+	if len(value) == 0 || value == "true" || value == "false" {
+		return nil, nil, nil, nil
+	}
+	infos := strings.SplitN(value, ":", 2)
+	mode := minio.RetentionMode(strings.ToUpper(infos[0]))
+	if len(infos) != 2 || !mode.IsValid() || len(infos[1]) < 2 {
+		return nil, nil, nil, fmt.Errorf("invalid object lock %s: expected true or governance|compliance:<n>d|y", value)
+	}
+	var unit minio.ValidityUnit
+	switch infos[1][len(infos[1])-1] {
+	case 'd':
+		unit = minio.Days
+	case 'y':
+		unit = minio.Years
+	default:
+		return nil, nil, nil, fmt.Errorf("invalid object lock validity %s", infos[1])
+	}
+	n, err := strconv.Atoi(infos[1][:len(infos[1])-1])
+	if err != nil || n < 1 {
+		return nil, nil, nil, fmt.Errorf("invalid object lock validity %s", infos[1])
+	}
+	validity := uint(n)
+	return &mode, &validity, &unit, nil
+}
+
+// bucketTags gets the bucket tags from the tag.<key>=<value> options
+func bucketTags(options map[string]string) (*tags.Tags, error) {
+	tagMap := make(map[string]string)
+	for key, value := range options {
+		if strings.HasPrefix(key, tagOptionPrefix) {
+			tagMap[strings.TrimPrefix(key, tagOptionPrefix)] = value
+		}
+	}
+	if len(tagMap) == 0 {
+		return nil, nil
+	}
+	return tags.NewTags(tagMap, false)
+}
+
+// bucketPolicy builds a canned bucket policy
+func bucketPolicy(bucket string, policy string) string {
+	var statements []string
+	for _, p := range []string{policyDownload, policyUpload} {
+		if policy != p && policy != policyPublic {
+			continue
+		}
+		for _, s := range policyStatements[p] {
+			resource := bucket
+			if s.objects {
+				resource += "/*"
+			}
+			statements = append(statements, fmt.Sprintf(bucketStatement, strings.Join(s.actions, `","`), resource))
+		}
+	}
+	return fmt.Sprintf(`{"Version":"2012-10-17","Statement":[%s]}`, strings.Join(statements, ","))
+}
+
+// lifecycleRules builds the lifecycle of a volume bucket, empty without rules
+func lifecycleRules(options map[string]string) string {
+	rules := ""
+	if days := options["lifecycle_expire_days"]; len(days) > 0 {
+		rules += fmt.Sprintf("<Expiration><Days>%s</Days></Expiration>", days)
+	}
+	if days := options["lifecycle_noncurrent_days"]; len(days) > 0 {
+		rules += fmt.Sprintf("<NoncurrentVersionExpiration><NoncurrentDays>%s</NoncurrentDays></NoncurrentVersionExpiration>", days)
+	}
+	if days := options["lifecycle_abort_days"]; len(days) > 0 {
+		rules += fmt.Sprintf("<AbortIncompleteMultipartUpload><DaysAfterInitiation>%s</DaysAfterInitiation></AbortIncompleteMultipartUpload>", days)
+	}
+	if len(rules) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(bucketLifecycle, rules)
+}
+
+// checkBucketOptions validates the bucket options of a volume
+func checkBucketOptions(options map[string]string) error {
+	_, _, _, err := parseObjectLock(options["object_lock"])
+	if err != nil {
+		return err
+	}
+	err = checkEncryption(options)
+	if err != nil {
+		return err
+	}
+	_, err = bucketTags(options)
+	if err != nil {
+		return fmt.Errorf("invalid bucket tags: %s", err)
+	}
+	switch options["policy"] {
+	case "", policyNone, policyDownload, policyUpload, policyPublic:
+	default:
+		return fmt.Errorf("unknown bucket policy %s", options["policy"])
+	}
+	for _, key := range []string{"lifecycle_expire_days", "lifecycle_noncurrent_days", "lifecycle_abort_days"} {
+		if len(options[key]) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(options[key])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid %s: %s", key, options[key])
+		}
+	}
+	return nil
+}
+
+// checkRegion refuses a volume region other than the region of the endpoint:
+// requests and mounts are signed for the endpoint region
+func checkRegion(options map[string]string, region string) error {
+	if len(options["region"]) > 0 && options["region"] != region {
+		return fmt.Errorf("region %s differs from the endpoint region %s", options["region"], region)
+	}
+	return nil
+}
+
+// bucketOptions gets the options applied to the bucket of a volume
+func bucketOptions(options map[string]string, minioQuota bool) map[string]string {
+	applied := make(map[string]string)
+	for key, value := range options {
+		switch {
+		case key == "versioning", key == "object_lock", key == "policy", key == "kms_key_id":
+		case key == "encrypt" && (value == encryptSSES3 || value == encryptSSEKMS):
+		case key == "quota" && minioQuota:
+		case strings.HasPrefix(key, tagOptionPrefix), strings.HasPrefix(key, "lifecycle_"):
+		default:
+			continue
+		}
+		applied[key] = value
+	}
+	return applied
+}
+
+// changedBucketOptions lists the bucket options that differ from the options
+// a bucket was configured with
+func changedBucketOptions(configured map[string]string, options map[string]string, minioQuota bool) []string {
+	var changed []string
+	before := bucketOptions(configured, minioQuota)
+	after := bucketOptions(options, minioQuota)
+	for key, value := range after {
+		if old, ok := before[key]; !ok || old != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// createVolumeBucket creates the bucket of a volume if it does not exist and
+// tells if it was created. The existence is not taken from the cache so that
+// a bucket created by another host is never removed on rollback.
+func (d *S3fsDriver) createVolumeBucket(bucket string, options map[string]string) (bool, error) {
+	exists, err := d.s3client.BucketExists(bucket)
+	if err != nil {
+		return false, fmt.Errorf("could not check existance of bucket %s: %s", bucket, err)
+	}
+	if exists {
+		return false, nil
+	}
+	region := d.conf["region"]
+	log.WithField("command", "driver").WithField("method", "create").Infof("creating bucket %s in region %s", bucket, region)
+	if len(options["object_lock"]) > 0 && options["object_lock"] != "false" {
+		err = d.s3client.MakeBucketWithObjectLock(bucket, region)
+	} else {
+		err = d.s3client.MakeBucket(bucket, region)
+	}
+	d.invalidateCache()
+	if minio.ToErrorResponse(err).Code == "BucketAlreadyOwnedByYou" {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not create bucket %s: %s", bucket, err)
+	}
+	return true, nil
+}
+
+// configureBucket applies the bucket options of a volume
+func (d *S3fsDriver) configureBucket(bucket string, options map[string]string) error {
+	if options["versioning"] == "true" {
+		err := d.s3client.EnableVersioning(bucket)
+		if err != nil {
+			return fmt.Errorf("could not enable versioning: %s", err)
+		}
+	}
+	mode, validity, unit, _ := parseObjectLock(options["object_lock"])
+	if mode != nil {
+		err := d.s3client.SetBucketObjectLockConfig(bucket, mode, validity, unit)
+		if err != nil {
+			return fmt.Errorf("could not set object lock retention: %s", err)
+		}
+	}
+	// customer keys cannot be a bucket default
+	if options["encrypt"] == encryptSSES3 || options["encrypt"] == encryptSSEKMS {
+		apply := minio.ApplyServerSideEncryptionByDefault{SSEAlgorithm: "AES256"}
+		if options["encrypt"] == encryptSSEKMS {
+			apply = minio.ApplyServerSideEncryptionByDefault{SSEAlgorithm: "aws:kms", KmsMasterKeyID: options["kms_key_id"]}
+		}
+		err := d.s3client.SetBucketEncryption(bucket, minio.ServerSideEncryptionConfiguration{Rules: []minio.Rule{{Apply: apply}}})
+		if err != nil {
+			return fmt.Errorf("could not set default encryption: %s", err)
+		}
+	}
+	bTags, _ := bucketTags(options)
+	if bTags != nil {
+		err := d.s3client.SetBucketTagging(bucket, bTags)
+		if err != nil {
+			return fmt.Errorf("could not set bucket tags: %s", err)
+		}
+	}
+	if policy := options["policy"]; len(policy) > 0 && policy != policyNone {
+		err := d.s3client.SetBucketPolicy(bucket, bucketPolicy(bucket, policy))
+		if err != nil {
+			return fmt.Errorf("could not set bucket policy: %s", err)
+		}
+	}
+	if lifecycle := lifecycleRules(options); len(lifecycle) > 0 {
+		err := d.s3client.SetBucketLifecycle(bucket, lifecycle)
+		if err != nil {
+			return fmt.Errorf("could not set bucket lifecycle: %s", err)
+		}
+	}
+	if len(options["quota"]) > 0 && d.conf["minioquota"] == "true" {
+		err := d.setMinioQuota(bucket, options["quota"])
+		if err != nil {
+			return fmt.Errorf("could not set quota: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package dockerVolumeS3
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckRegion(t *testing.T) {
+	tests := []struct {
+		region string
+		ok     bool
+	}{
+		{"", true},
+		{"us-east-1", true},
+		{"eu-west-1", false},
+	}
+	for _, tt := range tests {
+		if err := checkRegion(map[string]string{"region": tt.region}, "us-east-1"); (err == nil) != tt.ok {
+			t.Errorf("checkRegion(%q) = %v, want ok %v", tt.region, err, tt.ok)
+		}
+	}
+}
+
+func TestChangedBucketOptions(t *testing.T) {
+	tests := []struct {
+		configured map[string]string
+		options    map[string]string
+		minioQuota bool
+		changed    []string
+	}{
+		{nil, map[string]string{}, false, nil},
+		{nil, map[string]string{"nonempty": "true", "seed": "/seeds/web.tar", "encrypt": "sse-c"}, false, nil},
+		{nil, map[string]string{"versioning": "true", "tag.owner": "alice"}, false, []string{"tag.owner", "versioning"}},
+		{nil, map[string]string{"encrypt": "sse-kms", "kms_key_id": "key"}, false, []string{"encrypt", "kms_key_id"}},
+		{nil, map[string]string{"quota": "1GiB"}, false, nil},
+		{nil, map[string]string{"quota": "1GiB"}, true, []string{"quota"}},
+		{map[string]string{"versioning": "true", "lifecycle_expire_days": "30"}, map[string]string{"versioning": "true", "lifecycle_expire_days": "30"}, false, nil},
+		{map[string]string{"versioning": "true", "lifecycle_expire_days": "30"}, map[string]string{"lifecycle_expire_days": "7"}, false, []string{"lifecycle_expire_days", "versioning"}},
+		{map[string]string{"policy": "download"}, map[string]string{"policy": "public"}, false, []string{"policy"}},
+	}
+	for _, tt := range tests {
+		if got := changedBucketOptions(tt.configured, tt.options, tt.minioQuota); !reflect.DeepEqual(got, tt.changed) {
+			t.Errorf("changedBucketOptions(%v, %v) = %v, want %v", tt.configured, tt.options, got, tt.changed)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package dockerVolumeS3
+
+import (
+	"time"
+
+	"github.com/minio/minio-go/v6"
+	log "github.com/sirupsen/logrus"
+)
+
+// bucketState is the cached existence of a bucket
+type bucketState struct {
+	exists  bool
+	checked time.Time
+}
+
+// cacheTTL gets the time bucket and volume metadata are cached
+func (d *S3fsDriver) cacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(d.conf["cachettl"])
+	if err != nil {
+		return 0
+	}
+	return ttl
+}
+
+// fresh tells if cached data is still valid
+func (d *S3fsDriver) fresh(t time.Time) bool {
+	return !t.IsZero() && time.Since(t) < d.cacheTTL()
+}
+
+// invalidateCache drops the cached bucket and volume metadata
+func (d *S3fsDriver) invalidateCache() {
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+	log.WithField("command", "cache").Debugf("invalidating cache")
+	d.buckets = nil
+	d.bucketsListed = time.Time{}
+	d.bucketStates = make(map[string]bucketState)
+	d.volumes = nil
+	d.volumesLoaded = time.Time{}
+}
+
+// listBuckets lists the buckets, from the cache if it is fresh
+func (d *S3fsDriver) listBuckets() ([]minio.BucketInfo, error) {
+	d.cacheLock.Lock()
+	if d.fresh(d.bucketsListed) {
+		buckets := d.buckets
+		d.cacheLock.Unlock()
+		return buckets, nil
+	}
+	d.cacheLock.Unlock()
+	buckets, err := d.s3client.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+	d.buckets = buckets
+	d.bucketsListed = time.Now()
+	return buckets, nil
+}
+
+// cachedBucket gets the info of a bucket from a fresh bucket list
+func (d *S3fsDriver) cachedBucket(bucket string) (minio.BucketInfo, bool) {
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+	if !d.fresh(d.bucketsListed) {
+		return minio.BucketInfo{}, false
+	}
+	for _, b := range d.buckets {
+		if b.Name == bucket {
+			return b, true
+		}
+	}
+	return minio.BucketInfo{}, true
+}
+
+// bucketExists checks if a bucket exists, from the cache if it is fresh
+func (d *S3fsDriver) bucketExists(bucket string) (bool, error) {
+	if info, ok := d.cachedBucket(bucket); ok {
+		return len(info.Name) > 0, nil
+	}
+	d.cacheLock.Lock()
+	state, ok := d.bucketStates[bucket]
+	d.cacheLock.Unlock()
+	if ok && d.fresh(state.checked) {
+		return state.exists, nil
+	}
+	exists, err := d.s3client.BucketExists(bucket)
+	if err != nil {
+		return false, err
+	}
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+	d.bucketStates[bucket] = bucketState{exists: exists, checked: time.Now()}
+	return exists, nil
+}
+
+// cachedVolumes gets a copy of the registry if the cache is fresh
+func (d *S3fsDriver) cachedVolumes() (map[string]*VolConfig, bool) {
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+	if !d.fresh(d.volumesLoaded) {
+		return nil, false
+	}
+	return copyVolumes(d.volumes), true
+}
+
+// cacheVolumes caches a copy of the registry
+func (d *S3fsDriver) cacheVolumes(vols map[string]*VolConfig) {
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+	d.volumes = copyVolumes(vols)
+	d.volumesLoaded = time.Now()
+}
+
+// copyVolumes copies a registry map
+func copyVolumes(vols map[string]*VolConfig) map[string]*VolConfig {
+	res := make(map[string]*VolConfig)
+	for name, vol := range vols {
+		res[name] = vol
+	}
+	return res
+}
@@ -16,9 +16,25 @@ func (d *S3fsDriver) configure() {
 	d.conf["endpoint"] = "http://"
 	d.conf["region"] = "us-east-1"
 	d.conf["rootmount"] = "/mnt"
-	d.conf["replaceunderscores"] = "true"
 	d.conf["usessl"] = "true"
+	d.conf["addressing"] = "auto"
 	d.conf["mountdir"] = "/data"
+	d.conf["configbucket"] = "docker-volume-s3"
+	d.conf["remove_policy"] = "delete"
+	d.conf["archive_prefix"] = "archive/"
+	d.conf["copyconcurrency"] = "8"
+	d.conf["usagebackend"] = "list"
+	d.conf["usageinterval"] = "5m"
+	d.conf["cachettl"] = "30s"
+	d.conf["rclonepath"] = "rclone"
+	d.conf["vaultkeyfield"] = "key"
+	d.conf["quota_soft"] = "90"
+	d.conf["quota_action"] = "readonly"
+	d.conf["unmountstrategy"] = "retry"
+	d.conf["unmountretries"] = "3"
+	d.conf["unmountbackoff"] = "500ms"
+	d.conf["mountmarkerttl"] = "1h"
+	d.conf["lockttl"] = "1m"
 
 	d.loadEnvironmentS3ConfigVars()
 
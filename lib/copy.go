@@ -0,0 +1,183 @@
+package dockerVolumeS3
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/encrypt"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	copyProgressInterval = 10 * time.Second
+	clonesPrefix         = "clones/"
+	// maxCopySize is the largest object a single copy request can copy
+	maxCopySize = 5 * 1024 * 1024 * 1024
+)
+
+// copyJob is an object copied between buckets
+type copyJob struct {
+	src  string
+	dst  string
+	etag string
+	size int64
+}
+
+// listCopyJobs lists the objects of a bucket to copy them below a prefix
+func (d *S3fsDriver) listCopyJobs(bucket string, prefix string) ([]copyJob, error) {
+	var jobs []copyJob
+	for object := range d.s3client.ListObjects(bucket, "", true, nil) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("could not list bucket '%s': %s", bucket, object.Err)
+		}
+		jobs = append(jobs, copyJob{src: object.Key, dst: prefix + object.Key, etag: object.ETag, size: object.Size})
+	}
+	return jobs, nil
+}
+
+// copyObjects copies objects server side with bounded concurrency. Objects
+// already below the destination prefix with the same ETag are skipped so an
+// interrupted copy can be resumed. Copies are encrypted with dstSSE.
+func (d *S3fsDriver) copyObjects(srcBucket string, dstBucket string, dstPrefix string, jobs []copyJob, dstSSE encrypt.ServerSide) error {
+	srcSSE, err := d.bucketServerSide(srcBucket)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]string)
+	for object := range d.s3client.ListObjects(dstBucket, dstPrefix, true, nil) {
+		if object.Err != nil {
+			return fmt.Errorf("could not list bucket '%s': %s", dstBucket, object.Err)
+		}
+		existing[object.Key] = object.ETag
+	}
+	concurrency, err := strconv.Atoi(d.conf["copyconcurrency"])
+	if err != nil || concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		mutex   sync.Mutex
+		wg      sync.WaitGroup
+		errs    []string
+		copied  int
+		skipped int
+		size    int64
+	)
+	progress := func() {
+		log.WithField("command", "driver").WithField("method", "copy").Infof("copied %d/%d objects (%d bytes, %d skipped) from %s to %s", copied+skipped, len(jobs), size, skipped, srcBucket, dstBucket)
+	}
+	jobsCh := make(chan copyJob)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobsCh {
+				err := d.copyObject(srcBucket, job.src, srcSSE, job.size, dstBucket, job.dst, dstSSE)
+				mutex.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %s", job.src, err))
+				} else {
+					copied++
+					size += job.size
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(copyProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mutex.Lock()
+				progress()
+				mutex.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+	for _, job := range jobs {
+		if etag, ok := existing[job.dst]; ok && len(job.etag) > 0 && etag == job.etag {
+			mutex.Lock()
+			skipped++
+			mutex.Unlock()
+			continue
+		}
+		jobsCh <- job
+	}
+	close(jobsCh)
+	wg.Wait()
+	close(done)
+	progress()
+	if len(errs) > 0 {
+		return fmt.Errorf("could not copy %d objects: %s", len(errs), summarizeErrors(errs))
+	}
+	return nil
+}
+
+// cloneVolume copies the objects of a volume into a bucket
+func (d *S3fsDriver) cloneVolume(name string, bucket string, sse encrypt.ServerSide) error {
+	src, err := d.volumeBucket(name)
+	if err != nil {
+		return err
+	}
+	if src == bucket {
+		return fmt.Errorf("cannot clone bucket %s into itself", bucket)
+	}
+	log.WithField("command", "driver").WithField("method", "clone").Infof("cloning volume %s into bucket %s", name, bucket)
+	jobs, err := d.listCopyJobs(src, "")
+	if err != nil {
+		return err
+	}
+	return d.copyObjects(src, bucket, "", jobs, sse)
+}
+
+// markClone records that a volume is being cloned from a source volume, so
+// that an interrupted clone can be resumed
+func (d *S3fsDriver) markClone(name string, source string) error {
+	sse, err := d.serverSide(nil)
+	if err != nil {
+		return err
+	}
+	reader := strings.NewReader(source)
+	_, err = d.s3client.PutObject(d.conf["configbucket"], clonesPrefix+name, reader, reader.Size(), minio.PutObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		return fmt.Errorf("could not put clone marker: %s", err)
+	}
+	return nil
+}
+
+// cloneSource gets the source of an interrupted clone of a volume, empty if
+// the volume is not being cloned
+func (d *S3fsDriver) cloneSource(name string) (string, error) {
+	var source []byte
+	err := d.readObject(d.conf["configbucket"], clonesPrefix+name, func(r io.Reader) error {
+		var err error
+		source, err = ioutil.ReadAll(r)
+		return err
+	})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return "", nil
+		}
+		return "", fmt.Errorf("could not read clone marker: %s", err)
+	}
+	return string(source), nil
+}
+
+// unmarkClone removes the record of the clone of a volume once it is complete
+func (d *S3fsDriver) unmarkClone(name string) error {
+	err := d.s3client.RemoveObject(d.conf["configbucket"], clonesPrefix+name)
+	if err != nil {
+		return fmt.Errorf("could not remove clone marker: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package dockerVolumeS3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	keysPrefix       = "keys/"
+	dataKeyAlgorithm = "AES-256-GCM"
+	backendRclone    = "rclone-crypt"
+	rcloneS3Remote   = "VOLUMES3"
+	rcloneCrypt      = "VOLUMECRYPT"
+)
+
+// DataKey is the data key of a client side encrypted volume, encrypted with
+// the master key
+type DataKey struct {
+	Volume     string    `json:"volume"`
+	Algorithm  string    `json:"algorithm"`
+	MasterKey  string    `json:"master_key"`
+	Nonce      []byte    `json:"nonce"`
+	WrappedKey []byte    `json:"wrapped_key"`
+	Created    time.Time `json:"created"`
+}
+
+// dataKeyObject gets the object of the data key of a volume
+func dataKeyObject(name string) string {
+	return keysPrefix + name + ".json"
+}
+
+// masterKey gets the master key from a file or from vault, and describes
+// where it comes from
+func (d *S3fsDriver) masterKey() ([]byte, string, error) {
+	if file := d.conf["masterkeyfile"]; len(file) > 0 {
+		key, err := readKeyFile(file)
+		return key, "file:" + file, err
+	}
+	if len(d.conf["vaultaddress"]) > 0 {
+		key, err := d.vaultKey()
+		return key, "vault:" + d.conf["vaultkeypath"], err
+	}
+	return nil, "", fmt.Errorf("no master key: set masterkeyfile or vaultaddress")
+}
+
+// vaultKey reads the base64 encoded master key from a vault kv secret
+func (d *S3fsDriver) vaultKey() ([]byte, error) {
+	u := strings.TrimRight(d.conf["vaultaddress"], "/") + "/v1/" + strings.TrimLeft(d.conf["vaultkeypath"], "/")
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", d.conf["vaulttoken"])
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not get master key from vault: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not get master key from vault: %s", resp.Status)
+	}
+	secret := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&secret)
+	if err != nil {
+		return nil, fmt.Errorf("could not read vault secret: %s", err)
+	}
+	data := secret.Data
+	// kv version 2 nests the secret
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, _ := data[d.conf["vaultkeyfield"]].(string)
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != sseKeySize {
+		return nil, fmt.Errorf("invalid master key in vault field %s", d.conf["vaultkeyfield"])
+	}
+	return key, nil
+}
+
+// newGCM gets an AES-GCM cipher for a key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// putDataKey encrypts the data key of a volume with the master key and saves
+// it in the configuration bucket
+func (d *S3fsDriver) putDataKey(name string, key []byte) error {
+	master, source, err := d.masterKey()
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(master)
+	if err != nil {
+		return err
+	}
+	dk := DataKey{
+		Volume:    name,
+		Algorithm: dataKeyAlgorithm,
+		MasterKey: source,
+		Nonce:     make([]byte, gcm.NonceSize()),
+		Created:   time.Now().UTC(),
+	}
+	_, err = rand.Read(dk.Nonce)
+	if err != nil {
+		return err
+	}
+	// the volume name binds the data key to its volume
+	dk.WrappedKey = gcm.Seal(nil, dk.Nonce, key, []byte(name))
+	data, err := json.MarshalIndent(dk, "", "  ")
+	if err != nil {
+		return err
+	}
+	sse, err := d.serverSide(nil)
+	if err != nil {
+		return err
+	}
+	_, err = d.s3client.PutObject(d.conf["configbucket"], dataKeyObject(name), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: "application/json", ServerSideEncryption: sse})
+	if err != nil {
+		return fmt.Errorf("could not put data key: %s", err)
+	}
+	return nil
+}
+
+// clientEncrypted tells if a registered volume is encrypted client side
+func clientEncrypted(vol *VolConfig) bool {
+	return vol != nil && vol.Options["client_encryption"] == "true"
+}
+
+// hasDataKey tells if there is a data key for a volume
+func (d *S3fsDriver) hasDataKey(name string) (bool, error) {
+	sse, err := d.serverSide(nil)
+	if err != nil {
+		return false, err
+	}
+	_, err = d.s3client.StatObject(d.conf["configbucket"], dataKeyObject(name), statOptions(sse))
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, fmt.Errorf("could not stat data key: %s", err)
+	}
+	return true, nil
+}
+
+// dataKey gets the decrypted data key of a volume, nil if there is none
+func (d *S3fsDriver) dataKey(name string) ([]byte, error) {
+	ok, err := d.hasDataKey(name)
+	if err != nil || !ok {
+		return nil, err
+	}
+	dk := DataKey{}
+	err = d.readObject(d.conf["configbucket"], dataKeyObject(name), func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(&dk)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read data key: %s", err)
+	}
+	if dk.Algorithm != dataKeyAlgorithm {
+		return nil, fmt.Errorf("unknown data key algorithm %s", dk.Algorithm)
+	}
+	master, _, err := d.masterKey()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	key, err := gcm.Open(nil, dk.Nonce, dk.WrappedKey, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt data key of volume %s with %s: %s", name, dk.MasterKey, err)
+	}
+	return key, nil
+}
+
+// setupDataKey saves the data key of a new volume: volumes populated from an
+// encrypted volume share its data key, other volumes get a random one. The
+// client_encryption option records the encryption in the registry.
+func (d *S3fsDriver) setupDataKey(name string, options map[string]string) (bool, error) {
+	encrypted := d.volumeOption(&VolConfig{Options: options}, "client_encryption") == "true"
+	delete(options, "client_encryption")
+	source := options["from"]
+	if len(options["restore_from"]) > 0 {
+		source = options["restore_from"]
+	}
+	if len(options["from_snapshot"]) > 0 {
+		source, _, _ = parseSnapshot(options["from_snapshot"])
+	}
+	if len(source) > 0 {
+		key, err := d.dataKey(source)
+		if err != nil {
+			return false, err
+		}
+		if key != nil {
+			options["client_encryption"] = "true"
+			return true, d.putDataKey(name, key)
+		}
+		if encrypted {
+			return false, fmt.Errorf("volume %s is not encrypted client side", source)
+		}
+	}
+	exists, err := d.hasDataKey(name)
+	if err != nil {
+		return false, err
+	}
+	if !encrypted {
+		// a key left by a removed volume would encrypt this one on mount
+		if exists {
+			return false, fmt.Errorf("data key %s of a removed volume is left: delete it or set client_encryption=true", dataKeyObject(name))
+		}
+		return false, nil
+	}
+	if len(options["seed"]) > 0 {
+		return false, fmt.Errorf("seeded volumes cannot be encrypted client side")
+	}
+	options["client_encryption"] = "true"
+	if exists {
+		_, err = d.dataKey(name)
+		return false, err
+	}
+	key := make([]byte, sseKeySize)
+	_, err = rand.Read(key)
+	if err != nil {
+		return false, err
+	}
+	log.WithField("command", "driver").WithField("method", "create").Infof("creating data key of volume %s", name)
+	return true, d.putDataKey(name, key)
+}
+
+// deleteDataKey removes the data key of a volume
+func (d *S3fsDriver) deleteDataKey(name string) error {
+	return d.s3client.RemoveObject(d.conf["configbucket"], dataKeyObject(name))
+}
+
+// obscure obscures a password for the rclone configuration
+func (d *S3fsDriver) obscure(password string) (string, error) {
+	cmd := exec.Command(d.conf["rclonepath"], "obscure", "-")
+	cmd.Stdin = strings.NewReader(password)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("could not obscure password: %s", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// rcloneEnv configures the rclone remotes of an encrypted volume
+func (d *S3fsDriver) rcloneEnv(vol *VolConfig, bucket string, key []byte) ([]string, error) {
+	password, err := d.obscure(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		return nil, err
+	}
+	s3 := "RCLONE_CONFIG_" + rcloneS3Remote + "_"
+	crypt := "RCLONE_CONFIG_" + rcloneCrypt + "_"
+	env := []string{
+		s3 + "TYPE=s3",
+		s3 + "PROVIDER=Other",
+		s3 + "ENDPOINT=" + d.conf["endpoint"],
+		s3 + "REGION=" + d.conf["region"],
+		s3 + "ACCESS_KEY_ID=" + d.conf["accesskey"],
+		s3 + "SECRET_ACCESS_KEY=" + d.conf["secretkey"],
+		s3 + "FORCE_PATH_STYLE=" + strconv.FormatBool(d.pathStyle(bucket)),
+		crypt + "TYPE=crypt",
+		crypt + "REMOTE=" + strings.ToLower(rcloneS3Remote) + ":" + bucket,
+		crypt + "PASSWORD=" + password,
+	}
+	switch d.volumeOption(vol, "encrypt") {
+	case encryptSSES3:
+		env = append(env, s3+"SERVER_SIDE_ENCRYPTION=AES256")
+	case encryptSSEKMS:
+		env = append(env, s3+"SERVER_SIDE_ENCRYPTION=aws:kms", s3+"SSE_KMS_KEY_ID="+d.volumeOption(vol, "kms_key_id"))
+	case encryptSSEC:
+		sseKey, err := readKeyFile(d.volumeOption(vol, "sse_c_key_file"))
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, s3+"SSE_CUSTOMER_ALGORITHM=AES256", s3+"SSE_CUSTOMER_KEY_BASE64="+base64.StdEncoding.EncodeToString(sseKey))
+	}
+	return env, nil
+}
+
+// mountRclone mounts a bucket through a rclone crypt remote
+func (d *S3fsDriver) mountRclone(name string, bucket string, path string, key []byte, readonly bool) error {
+	vol, err := d.getVolume(name)
+	if err != nil {
+		return err
+	}
+	env, err := d.rcloneEnv(vol, bucket, key)
+	if err != nil {
+		return err
+	}
+	args := []string{"mount", strings.ToLower(rcloneCrypt) + ":", path, "--daemon", "--allow-other"}
+	if readonly {
+		args = append(args, "--read-only")
+	}
+	args = append(args, d.tlsRcloneArgs()...)
+	log.WithField("command", "driver").WithField("method", "mount").Infof("cmd: %s %s", d.conf["rclonepath"], strings.Join(args, " "))
+	err = runCommandEnv(append(d.mountEnv(), env...), d.conf["rclonepath"], args...)
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "mount").Errorf("error executing the mount command: %s", err)
+		return fmt.Errorf("error executing the mount command: %s", err)
+	}
+	return nil
+}
+
+// mountBucket mounts the bucket of a volume with s3fs, or with rclone for
+// volumes registered as encrypted client side
+func (d *S3fsDriver) mountBucket(name string, bucket string, path string, readonly bool) error {
+	vol, err := d.getVolume(name)
+	if err != nil {
+		return err
+	}
+	if clientEncrypted(vol) {
+		key, err := d.dataKey(name)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			log.WithField("command", "driver").WithField("method", "mount").Errorf("data key of encrypted volume %s is missing", name)
+			return fmt.Errorf("data key of encrypted volume %s is missing", name)
+		}
+		return d.mountRclone(name, bucket, path, key, readonly)
+	}
+	// never mount encrypted data in clear
+	exists, err := d.hasDataKey(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		log.WithField("command", "driver").WithField("method", "mount").Errorf("volume %s has a data key but is not registered as encrypted", name)
+		return fmt.Errorf("volume %s has a data key but is not registered as encrypted", name)
+	}
+	options, err := d.mountOptions(name, bucket, readonly)
+	if err != nil {
+		return err
+	}
+	return d.mountS3fs(bucket, path, options)
+}
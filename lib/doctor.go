@@ -0,0 +1,242 @@
+package dockerVolumeS3
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+)
+
+const (
+	doctorTimeout = 10 * time.Second
+	doctorContent = "docker-volume-s3 doctor"
+)
+
+// doctorCheck is a diagnostic of the plugin environment
+type doctorCheck struct {
+	name   string
+	hint   string
+	client bool // the check needs the s3 client
+	check  func() (string, error)
+}
+
+// Doctor runs the diagnostics and writes a report, it fails if a check fails.
+// If the driver cannot be created, the checks that do not need the s3 client
+// still run with the configuration.
+func Doctor(w io.Writer) error {
+	failed, total := 0, 0
+	d, err := NewDriver()
+	if err != nil {
+		failed++
+		total++
+		fmt.Fprintf(w, "[FAIL] driver: %s\n       hint: fix the configuration in /etc/docker-volume/s3.env\n", err)
+		d = newDriver()
+	}
+	checks := []doctorCheck{
+		{"configuration", "set S3_CONF_ACCESSKEY, S3_CONF_SECRETKEY and S3_CONF_ENDPOINT in /etc/docker-volume/s3.env", false, d.checkConfiguration},
+		{"s3fs", "install s3fs or set S3_CONF_S3FSPATH", false, d.checkS3fs},
+		{"fuse", "load the fuse module and give the plugin access to /dev/fuse (--device /dev/fuse --cap-add SYS_ADMIN)", false, checkFuse},
+		{"dns", "check the endpoint host name and the resolver of the host", false, d.checkDNS},
+		{"tls", "check the endpoint certificate and that the host trusts its CA", false, d.checkTLS},
+		{"credentials", "check the access key, the secret key and the region", true, d.checkCredentials},
+		{"config bucket", "create S3_CONF_CONFIGBUCKET or allow the key to create buckets", true, d.checkConfigBucket},
+		{"round trip", "allow the key to create and delete buckets and objects", true, d.checkRoundTrip},
+	}
+	total += len(checks)
+	for _, c := range checks {
+		if c.client && d.s3client == nil {
+			fmt.Fprintf(w, "[SKIP] %s: the driver could not be created\n", c.name)
+			continue
+		}
+		detail, err := c.check()
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "[FAIL] %s: %s\n       hint: %s\n", c.name, err, c.hint)
+			continue
+		}
+		fmt.Fprintf(w, "[ OK ] %s: %s\n", c.name, detail)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, total)
+	}
+	return nil
+}
+
+// checkConfiguration validates the configuration
+func (d *S3fsDriver) checkConfiguration() (string, error) {
+	if len(d.conf["accesskey"]) == 0 || len(d.conf["secretkey"]) == 0 {
+		return "", fmt.Errorf("access key or secret key not set")
+	}
+	u, err := url.Parse(d.conf["endpoint"])
+	if err != nil || len(u.Host) == 0 {
+		return "", fmt.Errorf("invalid endpoint %s", d.conf["endpoint"])
+	}
+	return fmt.Sprintf("endpoint %s, region %s, mount root %s", d.conf["endpoint"], d.conf["region"], d.conf["rootmount"]), nil
+}
+
+// checkS3fs gets the version of the s3fs binary
+func (d *S3fsDriver) checkS3fs() (string, error) {
+	s3fspath := d.conf["s3fspath"]
+	if len(s3fspath) == 0 {
+		s3fspath = "s3fs"
+	}
+	out, err := exec.Command(s3fspath, "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("could not run %s: %s", s3fspath, err)
+	}
+	version := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+	return fmt.Sprintf("%s (%s)", s3fspath, version), nil
+}
+
+// checkFuse verifies the access to the fuse device
+func checkFuse() (string, error) {
+	f, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return "/dev/fuse is accessible", nil
+}
+
+// endpointHost gets the host and port of the endpoint
+func (d *S3fsDriver) endpointHost() (string, string, error) {
+	u, err := url.Parse(d.conf["endpoint"])
+	if err != nil {
+		return "", "", err
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return u.Hostname(), port, nil
+}
+
+// endpointProxy gets the proxy reaching the endpoint, nil without proxy
+func (d *S3fsDriver) endpointProxy() *url.URL {
+	u, err := url.Parse(d.conf["endpoint"])
+	if err != nil {
+		return nil
+	}
+	proxy, err := d.proxyConfig().ProxyFunc()(u)
+	if err != nil {
+		return nil
+	}
+	return proxy
+}
+
+// checkDNS resolves the endpoint host
+func (d *S3fsDriver) checkDNS() (string, error) {
+	if proxy := d.endpointProxy(); proxy != nil {
+		return fmt.Sprintf("skipped, the endpoint is reached through proxy %s", proxy.Redacted()), nil
+	}
+	host, _, err := d.endpointHost()
+	if err != nil {
+		return "", err
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s resolves to %s", host, strings.Join(addrs, ", ")), nil
+}
+
+// checkTLS verifies the certificate of the endpoint
+func (d *S3fsDriver) checkTLS() (string, error) {
+	if !strings.HasPrefix(d.conf["endpoint"], "https://") {
+		return "endpoint does not use tls", nil
+	}
+	if proxy := d.endpointProxy(); proxy != nil {
+		return fmt.Sprintf("skipped, the endpoint is reached through proxy %s", proxy.Redacted()), nil
+	}
+	host, port, err := d.endpointHost()
+	if err != nil {
+		return "", err
+	}
+	config, err := d.tlsConfig()
+	if err != nil {
+		return "", err
+	}
+	config.ServerName = host
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: doctorTimeout}, "tcp", net.JoinHostPort(host, port), config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	cert := conn.ConnectionState().PeerCertificates[0]
+	if time.Until(cert.NotAfter) < 14*24*time.Hour {
+		return "", fmt.Errorf("certificate %s expires on %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("certificate %s issued by %s valid until %s", cert.Subject.CommonName, cert.Issuer.CommonName, cert.NotAfter.Format(time.RFC3339)), nil
+}
+
+// checkCredentials verifies the credentials by listing the buckets
+func (d *S3fsDriver) checkCredentials() (string, error) {
+	buckets, err := d.s3client.ListBuckets()
+	if err != nil {
+		return "", fmt.Errorf("could not list buckets: %s", err)
+	}
+	return fmt.Sprintf("%d buckets visible", len(buckets)), nil
+}
+
+// checkConfigBucket verifies the access to the configuration bucket
+func (d *S3fsDriver) checkConfigBucket() (string, error) {
+	vols, err := d.loadVolumes()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s holds %d volumes", d.conf["configbucket"], len(vols)), nil
+}
+
+// checkRoundTrip creates, writes, reads and deletes a scratch bucket
+func (d *S3fsDriver) checkRoundTrip() (detail string, err error) {
+	bucket := fmt.Sprintf("docker-volume-s3-doctor-%d", time.Now().UnixNano())
+	object := "doctor"
+	err = d.s3client.MakeBucket(bucket, d.conf["region"])
+	if err != nil {
+		return "", fmt.Errorf("could not create bucket %s: %s", bucket, err)
+	}
+	defer func() {
+		rErr := d.s3client.RemoveBucket(bucket)
+		if rErr != nil && err == nil {
+			err = fmt.Errorf("could not delete bucket %s: %s", bucket, rErr)
+		}
+	}()
+	sse, err := d.serverSide(nil)
+	if err != nil {
+		return "", err
+	}
+	reader := strings.NewReader(doctorContent)
+	_, err = d.s3client.PutObject(bucket, object, reader, reader.Size(), minio.PutObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		return "", fmt.Errorf("could not write object: %s", err)
+	}
+	defer func() {
+		rErr := d.s3client.RemoveObject(bucket, object)
+		if rErr != nil && err == nil {
+			err = fmt.Errorf("could not delete object: %s", rErr)
+		}
+	}()
+	content := bytes.Buffer{}
+	err = d.readObject(bucket, object, func(r io.Reader) error {
+		_, err := content.ReadFrom(r)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not read object: %s", err)
+	}
+	if content.String() != doctorContent {
+		return "", fmt.Errorf("object content differs")
+	}
+	return fmt.Sprintf("created, wrote, read and deleted bucket %s", bucket), nil
+}
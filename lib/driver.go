@@ -3,21 +3,24 @@ package dockerVolumeS3
 import (
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/credentials"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	emptyVolume = `# s3vol configuration
-# volumename;bucket;options
+# volumename;bucket;options[;created]
 `
 	configObject = "volumes"
 	s3fspwdfile  = "/etc/passwd-s3fs"
@@ -26,9 +29,27 @@ const (
 //S3fsDriver is a volume driver over s3fs
 type S3fsDriver struct {
 	s3client   *minio.Client
+	httpClient *http.Client
+	seedClient *http.Client
 	mounts     map[string]int
+	callers    map[string]map[string]time.Time // mount time per caller ID
 	mountsLock sync.Mutex
-	conf       map[string]string // ceph config params
+	usage      map[string]VolumeUsage
+	scanning   map[string]bool
+	overQuota  map[string]bool
+	include    []bucketPattern // bucket filters
+	exclude    []bucketPattern
+	health     map[string]healthState // per mount point
+	healthLock sync.Mutex
+	// cached metadata
+	buckets       []minio.BucketInfo
+	bucketsListed time.Time
+	bucketStates  map[string]bucketState
+	volumes       map[string]*VolConfig
+	volumesLoaded time.Time
+	cacheLock     sync.Mutex
+	usageLock     sync.Mutex
+	conf          map[string]string // ceph config params
 }
 
 //VolConfig represents the configuration of a volume
@@ -36,17 +57,34 @@ type VolConfig struct {
 	Name    string
 	Bucket  string
 	Options map[string]string
+	Created time.Time
 }
 
-//NewDriver creates a new S3FS driver
-func NewDriver() (*S3fsDriver, error) {
+//newDriver creates a configured S3FS driver without s3 client
+func newDriver() *S3fsDriver {
 
 	driver := &S3fsDriver{
-		mounts: make(map[string]int),
-		conf:   make(map[string]string),
+		httpClient:   &http.Client{},
+		seedClient:   &http.Client{},
+		mounts:       make(map[string]int),
+		callers:      make(map[string]map[string]time.Time),
+		usage:        make(map[string]VolumeUsage),
+		scanning:     make(map[string]bool),
+		overQuota:    make(map[string]bool),
+		health:       make(map[string]healthState),
+		bucketStates: make(map[string]bucketState),
+		conf:         make(map[string]string),
 	}
 
 	driver.configure()
+	driver.seedClient.CheckRedirect = driver.checkSeedRedirect
+	return driver
+}
+
+//NewDriver creates a new S3FS driver
+func NewDriver() (*S3fsDriver, error) {
+
+	driver := newDriver()
 
 	logLevel := "3"
 
@@ -110,10 +148,62 @@ func NewDriver() (*S3fsDriver, error) {
 	accesskey := driver.conf["accesskey"]
 	secretkey := driver.conf["secretkey"]
 	region := driver.conf["region"]
-	replaceunderscores := driver.conf["replaceunderscores"]
 	mount := driver.conf["rootmount"]
 	mount = strings.TrimRight(mount, "/")
 	driver.conf["rootmount"] = mount
+	_, err = parseUnmountStrategies(driver.conf["unmountstrategy"])
+	if err == nil {
+		_, _, err = unmountSettings(driver.conf)
+	}
+	if err != nil {
+		log.WithField("command", "driver").Errorf("could not parse unmount strategy: %s", err)
+		return nil, fmt.Errorf("could not parse unmount strategy: %s", err)
+	}
+	err = checkRemovePolicy(driver.conf["remove_policy"])
+	if err == nil {
+		_, err = mountMarkerTTL(driver.conf)
+	}
+	if err == nil {
+		_, err = lockTTL(driver.conf)
+	}
+	if err == nil {
+		err = checkExpireDays(driver.conf["archive_expire_days"])
+	}
+	if err == nil {
+		_, _, err = driver.archiveTarget(nil)
+	}
+	if err == nil {
+		err = checkQuotaOptions(driver.conf)
+	}
+	if err == nil {
+		err = checkEncryption(driver.conf)
+	}
+	if err == nil {
+		_, err = driver.serverSide(nil)
+	}
+	if err != nil {
+		log.WithField("command", "driver").Errorf("could not parse volume defaults: %s", err)
+		return nil, fmt.Errorf("could not parse volume defaults: %s", err)
+	}
+	err = checkProxy(driver.conf["proxy"])
+	if err != nil {
+		log.WithField("command", "driver").Errorf("could not parse proxy: %s", err)
+		return nil, fmt.Errorf("could not parse proxy: %s", err)
+	}
+	err = driver.compileBucketFilters()
+	if err != nil {
+		log.WithField("command", "driver").Errorf("could not parse bucket filters: %s", err)
+		return nil, fmt.Errorf("could not parse bucket filters: %s", err)
+	}
+	if driver.conf["usagebackend"] != usageList && driver.conf["usagebackend"] != usageMinio {
+		log.WithField("command", "driver").Errorf("unknown usage backend: %s", driver.conf["usagebackend"])
+		return nil, fmt.Errorf("unknown usage backend: %s", driver.conf["usagebackend"])
+	}
+	lookup, err := bucketLookup(driver.conf["addressing"])
+	if err != nil {
+		log.WithField("command", "driver").Errorf("could not parse addressing: %s", err)
+		return nil, fmt.Errorf("could not parse addressing: %s", err)
+	}
 	defaults, err := parseOptions(driver.conf["options"])
 	if err != nil {
 		log.WithField("command", "driver").Errorf("could not parse options: %s", err)
@@ -125,23 +215,60 @@ func NewDriver() (*S3fsDriver, error) {
 		log.WithField("command", "driver").Errorf("could not write s3fs password file: %s", err)
 		return nil, fmt.Errorf("could not write s3fs password file: %s", err)
 	}
-	// add connection info to default options
-	defaults["url"] = u.String()
-	defaults["endpoint"] = region
-	// default use path request style for minio
-	defaults["use_path_request_style"] = "true"
+	if driver.conf["replaceunderscores"] != "false" {
+		log.WithField("command", "driver").Warnf("replaceunderscores is deprecated: new volumes get hashed bucket names, the underscore mapping only finds existing buckets of unregistered volumes, set it to false to disable it")
+	}
+	if _, ok := defaults["use_path_request_style"]; ok {
+		log.WithField("command", "driver").Warnf("use_path_request_style in options is replaced by the addressing setting")
+	}
 	log.WithField("command", "driver").Infof("endpoint: %s", endpoint)
 	log.WithField("command", "driver").Infof("use ssl: %v", usessl)
+	if proxy, err := driver.proxyFunc()(&http.Request{URL: u}); err == nil && proxy != nil {
+		log.WithField("command", "driver").Infof("proxy: %s", proxy.Redacted())
+	}
+	if driver.conf["insecure"] == "true" {
+		log.WithField("command", "driver").Warnf("tls certificates of the endpoint are not verified")
+	}
+	if len(driver.conf["clientcert"]) > 0 {
+		log.WithField("command", "driver").Warnf("s3fs does not support client certificates: only encrypted volumes mounted with rclone present them")
+	}
 	log.WithField("command", "driver").Infof("region: %s", region)
-	log.WithField("command", "driver").Infof("replace underscores: %v", replaceunderscores)
+	log.WithField("command", "driver").Infof("addressing: %s", driver.conf["addressing"])
 	log.WithField("command", "driver").Infof("mount: %s", mount)
+	log.WithField("command", "driver").Infof("unmount strategy: %s", driver.conf["unmountstrategy"])
+	log.WithField("command", "driver").Infof("config bucket: %s", driver.conf["configbucket"])
+	log.WithField("command", "driver").Infof("bucket prefix: %s", driver.conf["bucketprefix"])
+	log.WithField("command", "driver").Infof("remove policy: %s", driver.conf["remove_policy"])
 	log.WithField("command", "driver").Infof("default options: %s", defaults)
 	// get a s3 client
-	clt, err := minio.NewWithRegion(endpoint, accesskey, secretkey, usessl, region)
+	clt, err := minio.NewWithOptions(endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(accesskey, secretkey, ""),
+		Secure:       usessl,
+		Region:       region,
+		BucketLookup: lookup,
+	})
 	if err != nil {
 		log.WithField("command", "driver").Errorf("cannot get s3 client: %s", err)
 		return nil, fmt.Errorf("cannot get s3 client: %s", err)
 	}
+	transport, err := minio.DefaultTransport(usessl)
+	if err != nil {
+		log.WithField("command", "driver").Errorf("cannot get s3 transport: %s", err)
+		return nil, fmt.Errorf("cannot get s3 transport: %s", err)
+	}
+	if tr, ok := transport.(*http.Transport); ok {
+		tr.Proxy = driver.proxyFunc()
+		if usessl {
+			tr.TLSClientConfig, err = driver.tlsConfig()
+			if err != nil {
+				log.WithField("command", "driver").Errorf("cannot get tls configuration: %s", err)
+				return nil, fmt.Errorf("cannot get tls configuration: %s", err)
+			}
+		}
+	}
+	transport = &metricsTransport{base: transport, endpoint: endpoint}
+	clt.SetCustomTransport(transport)
+	driver.httpClient.Transport = transport
 	driver.s3client = clt
 	// return the driver
 	return driver, nil
@@ -150,112 +277,300 @@ func NewDriver() (*S3fsDriver, error) {
 //Create creates a volume
 func (d *S3fsDriver) Create(req *volume.CreateRequest) error {
 	log.WithField("command", "driver").WithField("method", "create").Debugf("request: %+v", req)
-	// check bucket name
-	bucket := req.Name
-	if strings.Contains(bucket, "_") && d.conf["replaceunderscores"] == "true" {
-		bucket = strings.ReplaceAll(bucket, "_", "-")
+	// check bucket name, existing buckets of the legacy mapping are reused
+	bucket, _, err := d.unregisteredBucket(req.Name)
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "create").Errorf("could not check existance of bucket %s: %s", bucket, err)
+		return fmt.Errorf("could not check existance of bucket %s: %s", bucket, err)
+	}
+	if bucket == d.conf["configbucket"] {
+		log.WithField("command", "driver").WithField("method", "create").Errorf("bucket '%s' is reserved for the plugin configuration", bucket)
+		return fmt.Errorf("bucket '%s' is reserved for the plugin configuration", bucket)
+	}
+	if !d.exposed(bucket) {
+		log.WithField("command", "driver").WithField("method", "create").Errorf("bucket '%s' is excluded by the bucket filters", bucket)
+		return fmt.Errorf("bucket '%s' is excluded by the bucket filters", bucket)
+	}
+	vols, err := d.loadVolumes()
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "create").Errorf("could not get volumes: %s", err)
+		return fmt.Errorf("could not get volumes: %s", err)
+	}
+	if owner := bucketOwner(vols, req.Name, bucket); len(owner) > 0 {
+		log.WithField("command", "driver").WithField("method", "create").Errorf("bucket '%s' belongs to volume %s", bucket, owner)
+		return fmt.Errorf("bucket '%s' belongs to volume %s", bucket, owner)
 	}
-	// check that the bucket exists
-	err := d.createBucket(bucket)
+	err = checkVolumeOptions(req.Options)
+	if err == nil {
+		err = checkRegion(req.Options, d.conf["region"])
+	}
+	if err == nil {
+		_, _, err = d.archiveTarget(&VolConfig{Options: req.Options})
+	}
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "create").Errorf("invalid options: %s", err)
+		return fmt.Errorf("invalid options: %s", err)
+	}
+	// volumes populated from another source need a new bucket, unless an
+	// interrupted clone of the same source is resumed
+	source, _ := populateSource(req.Options)
+	resume := false
+	if len(source) > 0 {
+		exists, err := d.s3client.BucketExists(bucket)
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "create").Errorf("could not check existance of bucket %s: %s", bucket, err)
+			return fmt.Errorf("could not check existance of bucket %s: %s", bucket, err)
+		}
+		if exists && resumablePopulate(source) {
+			from, err := d.cloneSource(req.Name)
+			if err != nil {
+				log.WithField("command", "driver").WithField("method", "create").Errorf("could not check clone of volume %s: %s", req.Name, err)
+				return fmt.Errorf("could not check clone of volume %s: %s", req.Name, err)
+			}
+			resume = from == req.Options["from"]
+		}
+		if exists && !resume {
+			log.WithField("command", "driver").WithField("method", "create").Errorf("bucket '%s' already exists", bucket)
+			return fmt.Errorf("bucket '%s' already exists", bucket)
+		}
+	}
+	// create and configure the bucket, removing it again on failure
+	created, err := d.createVolumeBucket(bucket, req.Options)
 	if err != nil {
 		log.WithField("command", "driver").WithField("method", "create").Errorf("could check bucket '%s': %s", bucket, err)
 		return fmt.Errorf("could check bucket '%s': %s", bucket, err)
 	}
+	keyCreated := false
+	rollback := func() {
+		if keyCreated {
+			rErr := d.deleteDataKey(req.Name)
+			if rErr != nil {
+				log.WithField("command", "driver").WithField("method", "create").Errorf("could not remove data key of volume %s: %s", req.Name, rErr)
+			}
+		}
+		if !created {
+			return
+		}
+		rErr := d.deleteBucket(bucket)
+		if rErr != nil {
+			log.WithField("command", "driver").WithField("method", "create").Errorf("could not remove bucket '%s': %s", bucket, rErr)
+		}
+	}
+	// existing buckets keep their configuration, a resumed clone was
+	// configured when its bucket was created
+	if created {
+		err = d.configureBucket(bucket, req.Options)
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "create").Errorf("could not configure bucket '%s': %s", bucket, err)
+			rollback()
+			return fmt.Errorf("could not configure bucket '%s': %s", bucket, err)
+		}
+	} else if !resume {
+		var configured map[string]string
+		if vol := vols[req.Name]; vol != nil {
+			configured = vol.Options
+		}
+		if changed := changedBucketOptions(configured, req.Options, d.conf["minioquota"] == "true"); len(changed) > 0 {
+			log.WithField("command", "driver").WithField("method", "create").Errorf("bucket options %s cannot be applied to the existing bucket '%s'", strings.Join(changed, ", "), bucket)
+			return fmt.Errorf("bucket options %s cannot be applied to the existing bucket '%s'", strings.Join(changed, ", "), bucket)
+		}
+	}
+	// client side encryption
+	keyCreated, err = d.setupDataKey(req.Name, req.Options)
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "create").Errorf("could not set up data key of volume %s: %s", req.Name, err)
+		rollback()
+		return fmt.Errorf("could not set up data key of volume %s: %s", req.Name, err)
+	}
+	// populate the volume from its source
+	if len(source) > 0 {
+		if resumablePopulate(source) {
+			err = d.markClone(req.Name, req.Options["from"])
+			if err != nil {
+				log.WithField("command", "driver").WithField("method", "create").Errorf("could not populate volume %s from %s: %s", req.Name, source, err)
+				rollback()
+				return fmt.Errorf("could not populate volume %s from %s: %s", req.Name, source, err)
+			}
+		}
+		err = d.populateVolume(bucket, req.Options)
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "create").Errorf("could not populate volume %s from %s: %s", req.Name, source, err)
+			if !resumablePopulate(source) {
+				rollback()
+			}
+			return fmt.Errorf("could not populate volume %s from %s: %s", req.Name, source, err)
+		}
+		if resumablePopulate(source) {
+			err = d.unmarkClone(req.Name)
+			if err != nil {
+				log.WithField("command", "driver").WithField("method", "create").Errorf("could not populate volume %s from %s: %s", req.Name, source, err)
+				return fmt.Errorf("could not populate volume %s from %s: %s", req.Name, source, err)
+			}
+		}
+	}
+	// register the volume
+	err = d.registerVolume(&VolConfig{Name: req.Name, Bucket: bucket, Options: req.Options})
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "create").Errorf("could not register volume %s: %s", req.Name, err)
+		rollback()
+		return fmt.Errorf("could not register volume %s: %s", req.Name, err)
+	}
 	return nil
 }
 
 //List lists volumes
 func (d *S3fsDriver) List() (*volume.ListResponse, error) {
 	log.WithField("command", "driver").WithField("method", "list").Debugf("list")
+	// get registered volumes
+	vols, err := d.loadVolumes()
+	if err != nil {
+		log.WithField("command", "driver").Errorf("could not get volumes: %s", err)
+		return nil, fmt.Errorf("could not get volumes: %s", err)
+	}
 	// get bucket infos
-	bucketInfos, err := d.s3client.ListBuckets()
+	bucketInfos, err := d.listBuckets()
 	if err != nil {
 		log.WithField("command", "driver").Errorf("could not get bucket infos: %s", err)
 		return nil, fmt.Errorf("could not get bucket infos: %s", err)
 	}
-	resp := make([]*volume.Volume, len(bucketInfos))
-	for i, v := range bucketInfos {
-		// search for the bucket creation date
-		creation := ""
-		resp[i] = &volume.Volume{
-			Name:       v.Name,
-			Mountpoint: fmt.Sprintf("%s/%s", d.conf["rootmount"], v.Name),
-			CreatedAt:  creation,
+	buckets := make(map[string]minio.BucketInfo)
+	for _, b := range bucketInfos {
+		buckets[b.Name] = b
+	}
+	var resp []*volume.Volume
+	claimed := make(map[string]bool)
+	for _, vol := range vols {
+		claimed[vol.Bucket] = true
+		resp = append(resp, d.volumeInfo(vol.Name, vol, buckets[vol.Bucket]))
+	}
+	// buckets not registered are volumes too
+	for _, b := range bucketInfos {
+		if claimed[b.Name] || !d.exposed(b.Name) {
+			continue
 		}
-		log.Info(v.Name)
+		name, ok := d.volumeName(b.Name)
+		if !ok {
+			continue
+		}
+		resp = append(resp, d.volumeInfo(name, nil, b))
 	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
 	return &volume.ListResponse{Volumes: resp}, nil
 }
 
 //Get gets a volume
 func (d *S3fsDriver) Get(req *volume.GetRequest) (*volume.GetResponse, error) {
 	log.WithField("command", "driver").WithField("method", "get").Debugf("request: %+v", req)
-	// get bucket infos
-	bucketInfos, err := d.s3client.ListBuckets()
+	vol, err := d.getVolume(req.Name)
 	if err != nil {
-		log.WithField("command", "driver").WithField("method", "get").Errorf("could not get bucket infos: %s", err)
-		return nil, fmt.Errorf("could not get bucket infos: %s", err)
+		log.WithField("command", "driver").WithField("method", "get").Errorf("could not get volume %s: %s", req.Name, err)
+		return nil, fmt.Errorf("could not get volume %s: %s", req.Name, err)
 	}
-	// get creation date
-	creation := ""
-	for _, b := range bucketInfos {
-		if req.Name == b.Name {
-			creation = b.CreationDate.UTC().Format(time.RFC3339)
-			break
+	bucket := d.bucketName(req.Name)
+	if vol != nil {
+		bucket = vol.Bucket
+	} else {
+		bucket, _, err = d.unregisteredBucket(req.Name)
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "get").Errorf("could not check existance of bucket %s: %s", bucket, err)
+			return nil, fmt.Errorf("could not check existance of bucket %s: %s", bucket, err)
 		}
 	}
-	return &volume.GetResponse{
-		Volume: &volume.Volume{
-			Name:       req.Name,
-			Mountpoint: fmt.Sprintf("%s/%s", d.conf["rootmount"], req.Name),
-			CreatedAt:  creation,
-		},
-	}, nil
+	// get bucket infos
+	info, ok := d.cachedBucket(bucket)
+	if !ok {
+		exists, err := d.bucketExists(bucket)
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "get").Errorf("could not check existance of bucket %s: %s", bucket, err)
+			return nil, fmt.Errorf("could not check existance of bucket %s: %s", bucket, err)
+		}
+		if exists {
+			info.Name = bucket
+		}
+	}
+	if vol == nil && (len(info.Name) == 0 || !d.exposed(bucket)) {
+		log.WithField("command", "driver").WithField("method", "get").Errorf("volume %s not found", req.Name)
+		return nil, fmt.Errorf("volume %s not found", req.Name)
+	}
+	return &volume.GetResponse{Volume: d.volumeInfo(req.Name, vol, info)}, nil
 }
 
 //Remove removes a volume
 func (d *S3fsDriver) Remove(req *volume.RemoveRequest) error {
 	log.WithField("command", "driver").WithField("method", "remove").Debugf("request: %+v", req)
-	// check bucket
-	buckets, err := d.s3client.ListBuckets()
+	return d.remove(req, false)
+}
+
+//ForceRemove removes a volume even if it is reported as mounted
+func (d *S3fsDriver) ForceRemove(name string) error {
+	log.WithField("command", "driver").WithField("method", "remove").Warnf("forcing removal of volume %s", name)
+	return d.remove(&volume.RemoveRequest{Name: name}, true)
+}
+
+// remove removes a volume, checking that it is not in use unless forced
+func (d *S3fsDriver) remove(req *volume.RemoveRequest, force bool) error {
+	// get volume configuration
+	vol, err := d.getVolume(req.Name)
 	if err != nil {
-		log.WithField("command", "driver").WithField("method", "remove").Errorf("could not list buckets: %s", err)
-		return fmt.Errorf("could not list buckets: %s", err)
-	}
-	for _, bucket := range buckets {
-		if bucket.Name == req.Name {
-			log.WithField("command", "driver").WithField("method", "remove").Infof("removing bucket: %s", req.Name)
-			// empty bucket
-			// channel of objects to remove
-			objectsCh := make(chan string)
-			// Send object names that are needed to be removed to objectsCh
-			go func() {
-				defer close(objectsCh)
-				// List all objects from a bucket
-				for object := range d.s3client.ListObjects(req.Name, "", true, nil) {
-					if object.Err != nil {
-						log.WithField("command", "driver").WithField("method", "remove").Errorf("removing object from bucket '%s': %s", req.Name, object.Err)
-						break
-					}
-					objectsCh <- object.Key
-				}
-			}()
-			// remove the obtained objects from channel
-			for rErr := range d.s3client.RemoveObjects(req.Name, objectsCh) {
-				log.WithField("command", "driver").WithField("method", "remove").Errorf("error emptying bucket '%s': %s", req.Name, rErr)
-				// don't exist: try to remove the bucket anyway
-				break
+		log.WithField("command", "driver").WithField("method", "remove").Errorf("could not get volume %s: %s", req.Name, err)
+		return fmt.Errorf("could not get volume %s: %s", req.Name, err)
+	}
+	bucket := d.bucketName(req.Name)
+	exists := false
+	if vol != nil {
+		bucket = vol.Bucket
+		exists, err = d.bucketExists(bucket)
+	} else {
+		bucket, exists, err = d.unregisteredBucket(req.Name)
+	}
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "remove").Errorf("could not check existance of bucket %s: %s", bucket, err)
+		return fmt.Errorf("could not check existance of bucket %s: %s", bucket, err)
+	}
+	if vol == nil && (!exists || !d.exposed(bucket)) {
+		log.WithField("command", "driver").WithField("method", "remove").Errorf("volume %s not found", req.Name)
+		return fmt.Errorf("volume %s not found", req.Name)
+	}
+	// refuse to remove volumes in use
+	if !force && d.volumeOption(vol, "force_remove") != "true" {
+		err = d.checkInUse(req.Name)
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "remove").Errorf("could not remove volume: %s", err)
+			return fmt.Errorf("could not remove volume: %s", err)
+		}
+	}
+	policy := d.volumeOption(vol, "remove_policy")
+	switch policy {
+	case removeRetain:
+		log.WithField("command", "driver").WithField("method", "remove").Infof("retaining bucket %s of volume %s", bucket, req.Name)
+	case removeArchive:
+		if exists {
+			err = d.archiveBucket(vol, req.Name, bucket)
+			if err != nil {
+				return err
 			}
-			// remove bucket
-			err = d.s3client.RemoveBucket(req.Name)
+			err = d.deleteBucket(bucket)
 			if err != nil {
-				log.WithField("command", "driver").WithField("method", "remove").Errorf("could not remove bucket: %s", err)
-				return fmt.Errorf("could not remove bucket: %s", err)
+				return err
 			}
-			break
+		}
+	default:
+		if exists {
+			err = d.deleteBucket(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		// snapshots still need the data key
+		snapshots, err := d.Snapshots(req.Name)
+		if err == nil && len(snapshots) == 0 {
+			err = d.deleteDataKey(req.Name)
+		}
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "remove").Warnf("could not remove data key of volume %s: %s", req.Name, err)
 		}
 	}
-	return nil
+	return d.deregisterVolume(req.Name)
 }
 
 //Path provides the path
@@ -272,16 +587,44 @@ func (d *S3fsDriver) Mount(req *volume.MountRequest) (*volume.MountResponse, err
 	path := fmt.Sprintf("%s/%s", d.conf["rootmount"], req.Name)
 	d.mountsLock.Lock()
 	defer d.mountsLock.Unlock()
-	if _, ok := d.mounts[req.Name]; ok {
+	if _, ok := d.mounts[req.Name]; !ok {
 		d.mounts[req.Name] = 0
 	}
 	if d.mounts[req.Name] > 0 {
-		d.mounts[req.Name]++
-		log.WithField("command", "driver").WithField("method", "mount").Infof("volume %s is used by %d containers", req.Name, d.mounts[req.Name])
-		return &volume.MountResponse{Mountpoint: path}, nil
+		if d.isHealthy(path) {
+			// running containers keep their read write mount over the quota
+			if d.isOverQuota(req.Name) && !isReadOnly(path) {
+				log.WithField("command", "driver").WithField("method", "mount").Errorf("volume %s is over its quota", req.Name)
+				return nil, fmt.Errorf("volume %s is over its quota", req.Name)
+			}
+			d.mounts[req.Name]++
+			d.addCaller(req.Name, req.ID)
+			log.WithField("command", "driver").WithField("method", "mount").Infof("volume %s is used by %d containers", req.Name, d.mounts[req.Name])
+			return &volume.MountResponse{Mountpoint: path + d.conf["mountdir"]}, nil
+		}
+		// the s3fs process died: mount the volume again
+		log.WithField("command", "driver").WithField("method", "mount").Warnf("mount of volume %s is not healthy, restarting s3fs", req.Name)
+		err := runCommand("fusermount", "-u", "-z", path)
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "mount").Warnf("could not unmount %s: %s", path, err)
+		}
+		s3fsRestarts.WithLabelValues(req.Name).Inc()
 	}
 
-	options := d.conf["options"]
+	bucket, err := d.volumeBucket(req.Name)
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "mount").Errorf("could not get volume %s: %s", req.Name, err)
+		return nil, fmt.Errorf("could not get volume %s: %s", req.Name, err)
+	}
+	// volumes over their quota are mounted read only or refused
+	readonly := d.isOverQuota(req.Name)
+	if readonly {
+		if d.quotaAction(req.Name) == quotaRefuse {
+			log.WithField("command", "driver").WithField("method", "mount").Errorf("volume %s is over its quota", req.Name)
+			return nil, fmt.Errorf("volume %s is over its quota", req.Name)
+		}
+		log.WithField("command", "driver").WithField("method", "mount").Warnf("volume %s is over its quota, mounting read only", req.Name)
+	}
 	// create path if not exists
 	info, err := os.Stat(path)
 	if err != nil && !os.IsNotExist(err) {
@@ -301,45 +644,91 @@ func (d *S3fsDriver) Mount(req *volume.MountRequest) (*volume.MountResponse, err
 			return nil, fmt.Errorf("mount path %s is not a directory: %s", path, err)
 		}
 	}
+	err = d.mountBucket(req.Name, bucket, path, readonly)
+	if err != nil {
+		return nil, err
+	}
+	// if mountdir is set but not exist, create it
+	if d.conf["mountdir"] != "" {
+		_, err = os.Stat(path + d.conf["mountdir"])
+		if err != nil && !os.IsNotExist(err) {
+			log.WithField("command", "driver").WithField("method", "mount").Errorf("could not get mount path %s %s: %s", path, d.conf["mountdir"], err)
+			return nil, fmt.Errorf("could not get mount path %s %s: %s", path, d.conf["mountdir"], err)
+		}
+		// create path
+		if os.IsNotExist(err) {
+			err := os.Mkdir(path+d.conf["mountdir"], 0770)
+			if err != nil {
+				log.WithField("command", "driver").WithField("method", "mount").Errorf("could not create mount path %s %s: %s", path, d.conf["mountdir"], err)
+				return nil, fmt.Errorf("could not create mount path %s %s: %s", path, d.conf["mountdir"], err)
+			}
+		}
+	}
+	err = d.markMounted(req.Name)
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "mount").Warnf("could not mark volume %s as mounted: %s", req.Name, err)
+	}
+	d.mounts[req.Name]++
+	d.addCaller(req.Name, req.ID)
+	log.WithField("command", "driver").WithField("method", "mount").Infof("volume %s is used by %d containers", req.Name, d.mounts[req.Name])
+	return &volume.MountResponse{Mountpoint: path + d.conf["mountdir"]}, nil
+}
+
+// mountOptions gets the s3fs options of a volume
+func (d *S3fsDriver) mountOptions(name string, bucket string, readonly bool) (string, error) {
+	opts, err := parseOptions(d.conf["options"])
+	if err != nil {
+		return "", err
+	}
+	// connection info, unless given in the options
+	if _, ok := opts["url"]; !ok {
+		opts["url"] = d.conf["endpoint"]
+	}
+	if _, ok := opts["endpoint"]; !ok {
+		opts["endpoint"] = d.conf["region"]
+	}
+	delete(opts, "use_path_request_style")
+	if d.pathStyle(bucket) {
+		opts["use_path_request_style"] = "true"
+	}
+	vol, err := d.getVolume(name)
+	if err != nil {
+		return "", err
+	}
+	if sse := d.sseMountOption(vol); len(sse) > 0 {
+		opts["use_sse"] = sse
+	}
+	d.tlsMountOptions(opts)
+	if readonly {
+		opts["ro"] = "true"
+	}
+	return optionsToString(opts), nil
+}
+
+// mountS3fs runs s3fs to mount a bucket
+func (d *S3fsDriver) mountS3fs(bucket string, path string, options string) error {
 	// generate command
-	cmd := fmt.Sprintf("%s %s %s -o %s", d.conf["s3fspath"], req.Name, path, options)
+	cmd := fmt.Sprintf("%s %s %s -o %s", d.conf["s3fspath"], bucket, path, options)
 	log.WithField("command", "driver").WithField("method", "mount").Infof("cmd: %s", cmd)
-	err = exec.Command("sh", "-c", cmd).Run()
+	command := exec.Command("sh", "-c", cmd)
+	command.Env = append(os.Environ(), d.mountEnv()...)
+	err := command.Run()
 	if err != nil {
 		switch e := err.(type) {
 		case *exec.ExitError:
 			if len(e.Stderr) > 0 {
 				message := strings.ReplaceAll(string(e.Stderr), "\n", "\\n")
 				log.WithField("command", "driver").WithField("method", "mount").Errorf("error executing the mount command: '%s'", message)
-				return nil, fmt.Errorf("error executing the mount command: '%s'", message)
+				return fmt.Errorf("error executing the mount command: '%s'", message)
 			}
 			log.WithField("command", "driver").WithField("method", "mount").Errorf("error executing the mount command: %s", err)
-			return nil, fmt.Errorf("error executing the mount command: %s", err)
+			return fmt.Errorf("error executing the mount command: %s", err)
 		default:
 			log.WithField("command", "driver").WithField("method", "mount").Errorf("error executing the mount command: %s", err)
-			return nil, fmt.Errorf("error executing the mount command: %s", err)
-		}
-	} else {
-		// if mountdir is set but not exist, create it
-		if d.conf["mountdir"] != "" {
-			_, err = os.Stat(path + d.conf["mountdir"])
-			if err != nil && !os.IsNotExist(err) {
-				log.WithField("command", "driver").WithField("method", "mount").Errorf("could not get mount path %s %s: %s", path, d.conf["mountdir"], err)
-				return nil, fmt.Errorf("could not get mount path %s %s: %s", path, d.conf["mountdir"], err)
-			}
-			// create path
-			if os.IsNotExist(err) {
-				err := os.Mkdir(path+d.conf["mountdir"], 0770)
-				if err != nil {
-					log.WithField("command", "driver").WithField("method", "mount").Errorf("could not create mount path %s %s: %s", path, d.conf["mountdir"], err)
-					return nil, fmt.Errorf("could not create mount path %s %s: %s", path, d.conf["mountdir"], err)
-				}
-			}
+			return fmt.Errorf("error executing the mount command: %s", err)
 		}
 	}
-	d.mounts[req.Name]++
-	log.WithField("command", "driver").WithField("method", "mount").Infof("volume %s is used by %d containers", req.Name, d.mounts[req.Name])
-	return &volume.MountResponse{Mountpoint: path + d.conf["mountdir"]}, nil
+	return nil
 }
 
 //Unmount unmounts a volume
@@ -351,32 +740,25 @@ func (d *S3fsDriver) Unmount(req *volume.UnmountRequest) error {
 	// check if other container still have this mounted
 	if d.mounts[req.Name] > 1 {
 		d.mounts[req.Name]--
+		delete(d.callers[req.Name], req.ID)
 		log.WithField("command", "driver").WithField("method", "unmount").Infof("volume %s is used by %d containers", req.Name, d.mounts[req.Name])
 		return nil
 	}
 	// generate mount path
 	path := fmt.Sprintf("%s/%s", d.conf["rootmount"], req.Name)
 	// unmount volume
-	// generate command
-	cmd := fmt.Sprintf("umount %s", path)
-	log.WithField("command", "driver").WithField("method", "unmount").Infof("cmd: %s", cmd)
-	err := exec.Command("sh", "-c", cmd).Run()
+	err := d.unmount(path)
 	if err != nil {
-		switch e := err.(type) {
-		case *exec.ExitError:
-			if len(e.Stderr) > 0 {
-				message := strings.ReplaceAll(string(e.Stderr), "\n", "\\n")
-				log.WithField("command", "driver").WithField("method", "umount").Errorf("error executing the umount command: '%s'", message)
-				return fmt.Errorf("error executing the umount command: '%s'", message)
-			}
-			log.WithField("command", "driver").WithField("method", "umount").Errorf("error executing the umount command: %s", err)
-			return fmt.Errorf("error executing the umount command: %s", err)
-		default:
-			log.WithField("command", "driver").WithField("method", "umount").Errorf("error executing the umount command: %s", err)
-			return fmt.Errorf("error executing the umount command: %s", err)
-		}
+		log.WithField("command", "driver").WithField("method", "unmount").Errorf("could not unmount volume %s: %s", req.Name, err)
+		return fmt.Errorf("could not unmount volume %s: %s", req.Name, err)
+	}
+	delete(d.mounts, req.Name)
+	delete(d.callers, req.Name)
+	d.forgetHealth(path)
+	err = d.unmarkMounted(req.Name)
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "unmount").Warnf("could not unmark volume %s as mounted: %s", req.Name, err)
 	}
-	d.mounts[req.Name]--
 	log.WithField("command", "driver").WithField("method", "unmount").Infof("volume %s is used by %d containers", req.Name, d.mounts[req.Name])
 	return nil
 }
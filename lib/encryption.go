@@ -0,0 +1,120 @@
+package dockerVolumeS3
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/encrypt"
+)
+
+const (
+	encryptSSEC = "sse-c"
+	sseKeySize  = 32
+)
+
+// checkEncryption validates the server side encryption options
+func checkEncryption(options map[string]string) error {
+	switch options["encrypt"] {
+	case "", encryptSSES3, encryptSSEKMS:
+	case encryptSSEC:
+		if len(options["sse_c_key_file"]) == 0 {
+			return fmt.Errorf("encrypt=%s requires sse_c_key_file", encryptSSEC)
+		}
+	default:
+		return fmt.Errorf("unknown encryption %s", options["encrypt"])
+	}
+	if len(options["kms_key_id"]) > 0 && options["encrypt"] != encryptSSEKMS {
+		return fmt.Errorf("kms_key_id requires encrypt=%s", encryptSSEKMS)
+	}
+	return nil
+}
+
+// readKeyFile reads a 256 bit key from the first line of a file, either raw
+// or base64 encoded
+func readKeyFile(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key: %s", err)
+	}
+	key := bytes.TrimSpace(bytes.SplitN(data, []byte("\n"), 2)[0])
+	if len(key) == sseKeySize {
+		return key, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(key))
+	if err != nil || len(decoded) != sseKeySize {
+		return nil, fmt.Errorf("invalid key in %s: expected %d bytes, raw or base64 encoded", file, sseKeySize)
+	}
+	return decoded, nil
+}
+
+// serverSide gets the server side encryption of a volume, the global one for
+// a nil volume
+func (d *S3fsDriver) serverSide(vol *VolConfig) (encrypt.ServerSide, error) {
+	switch d.volumeOption(vol, "encrypt") {
+	case encryptSSES3:
+		return encrypt.NewSSE(), nil
+	case encryptSSEKMS:
+		return encrypt.NewSSEKMS(d.volumeOption(vol, "kms_key_id"), nil)
+	case encryptSSEC:
+		key, err := readKeyFile(d.volumeOption(vol, "sse_c_key_file"))
+		if err != nil {
+			return nil, err
+		}
+		return encrypt.NewSSEC(key)
+	}
+	return nil, nil
+}
+
+// bucketServerSide gets the server side encryption of the objects of a
+// bucket: the one of its volume, or the global one for other buckets
+func (d *S3fsDriver) bucketServerSide(bucket string) (encrypt.ServerSide, error) {
+	if bucket != d.conf["configbucket"] {
+		vols, err := d.loadVolumes()
+		if err != nil {
+			return nil, err
+		}
+		for _, vol := range vols {
+			if vol.Bucket == bucket {
+				return d.serverSide(vol)
+			}
+		}
+	}
+	return d.serverSide(nil)
+}
+
+// customerKey keeps only SSE-C encryption, the only one needed to read objects
+func customerKey(sse encrypt.ServerSide) encrypt.ServerSide {
+	if sse == nil || sse.Type() != encrypt.SSEC {
+		return nil
+	}
+	return sse
+}
+
+// sseMountOption gets the s3fs use_sse option of a volume
+func (d *S3fsDriver) sseMountOption(vol *VolConfig) string {
+	switch d.volumeOption(vol, "encrypt") {
+	case encryptSSES3:
+		return "1"
+	case encryptSSEKMS:
+		if id := d.volumeOption(vol, "kms_key_id"); len(id) > 0 {
+			return "kmsid:" + id
+		}
+		return "kmsid"
+	case encryptSSEC:
+		return "custom:" + d.volumeOption(vol, "sse_c_key_file")
+	}
+	return ""
+}
+
+// getOptions gets the options to read an object encrypted with sse
+func getOptions(sse encrypt.ServerSide) minio.GetObjectOptions {
+	return minio.GetObjectOptions{ServerSideEncryption: customerKey(sse)}
+}
+
+// statOptions gets the options to stat an object encrypted with sse
+func statOptions(sse encrypt.ServerSide) minio.StatObjectOptions {
+	return minio.StatObjectOptions{GetObjectOptions: getOptions(sse)}
+}
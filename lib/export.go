@@ -0,0 +1,167 @@
+package dockerVolumeS3
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+	log "github.com/sirupsen/logrus"
+)
+
+// tarHeader builds a tar header from the s3fs metadata of an object
+func tarHeader(name string, info minio.ObjectInfo) *tar.Header {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     info.Size,
+		ModTime:  info.LastModified,
+		Typeflag: tar.TypeReg,
+	}
+	mode, err := strconv.ParseInt(metaValue(info.Metadata, "mode"), 10, 64)
+	if err == nil {
+		hdr.Mode = mode & 07777
+		switch mode & 0170000 {
+		case modeDir:
+			hdr.Typeflag = tar.TypeDir
+		case modeSymlink:
+			hdr.Typeflag = tar.TypeSymlink
+		}
+	}
+	if strings.HasSuffix(info.Key, "/") || info.ContentType == directoryContentType {
+		hdr.Typeflag = tar.TypeDir
+		if err != nil {
+			hdr.Mode = 0755
+		}
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		hdr.Size = 0
+	}
+	if uid, err := strconv.Atoi(metaValue(info.Metadata, "uid")); err == nil {
+		hdr.Uid = uid
+	}
+	if gid, err := strconv.Atoi(metaValue(info.Metadata, "gid")); err == nil {
+		hdr.Gid = gid
+	}
+	if mtime, err := strconv.ParseInt(metaValue(info.Metadata, "mtime"), 10, 64); err == nil {
+		hdr.ModTime = time.Unix(mtime, 0)
+	}
+	return hdr
+}
+
+// metaValue gets a s3fs metadata header of an object
+func metaValue(metadata http.Header, key string) string {
+	return metadata.Get(userMetaPrefix + key)
+}
+
+// Export writes the content of a volume as a tar archive
+func (d *S3fsDriver) Export(name string, w io.Writer) error {
+	bucket, err := d.volumeBucket(name)
+	if err != nil {
+		return err
+	}
+	sse, err := d.bucketServerSide(bucket)
+	if err != nil {
+		return err
+	}
+	vol, err := d.getVolume(name)
+	if err != nil {
+		return err
+	}
+	if clientEncrypted(vol) {
+		return fmt.Errorf("volume %s is encrypted client side: export it from a mount", name)
+	}
+	log.WithField("command", "driver").WithField("method", "export").Infof("exporting volume %s", name)
+	prefix := d.dataPrefix()
+	tw := tar.NewWriter(w)
+	dirs := make(map[string]bool)
+	// addParents adds the implicit parent directories of an entry
+	addParents := func(name string) error {
+		var parents []string
+		for dir := path.Dir(name); dir != "." && dir != "/" && !dirs[dir]; dir = path.Dir(dir) {
+			parents = append(parents, dir)
+		}
+		for i := len(parents) - 1; i >= 0; i-- {
+			dirs[parents[i]] = true
+			err := tw.WriteHeader(&tar.Header{Name: parents[i] + "/", Mode: 0755, Typeflag: tar.TypeDir, ModTime: time.Now()})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	count := 0
+	for object := range d.s3client.ListObjects(bucket, prefix, true, nil) {
+		if object.Err != nil {
+			return fmt.Errorf("could not list bucket '%s': %s", bucket, object.Err)
+		}
+		entry := strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), "/")
+		if len(entry) == 0 || dirs[entry] {
+			continue
+		}
+		info, err := d.s3client.StatObject(bucket, object.Key, statOptions(sse))
+		if err != nil {
+			return fmt.Errorf("could not stat %s: %s", object.Key, err)
+		}
+		err = addParents(entry)
+		if err != nil {
+			return fmt.Errorf("could not write archive: %s", err)
+		}
+		hdr := tarHeader(entry, info)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			dirs[entry] = true
+			hdr.Name = entry + "/"
+			err = tw.WriteHeader(hdr)
+		case tar.TypeSymlink:
+			err = d.readObject(bucket, object.Key, func(r io.Reader) error {
+				target := bytes.Buffer{}
+				_, err := target.ReadFrom(r)
+				if err != nil {
+					return err
+				}
+				hdr.Linkname = target.String()
+				return tw.WriteHeader(hdr)
+			})
+		default:
+			err = tw.WriteHeader(hdr)
+			if err == nil {
+				err = d.readObject(bucket, object.Key, func(r io.Reader) error {
+					_, err := io.Copy(tw, r)
+					return err
+				})
+			}
+		}
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "export").Errorf("could not export %s: %s", object.Key, err)
+			return fmt.Errorf("could not export %s: %s", object.Key, err)
+		}
+		count++
+	}
+	err = tw.Close()
+	if err != nil {
+		return fmt.Errorf("could not write archive: %s", err)
+	}
+	log.WithField("command", "driver").WithField("method", "export").Infof("exported %d entries of volume %s", count, name)
+	return nil
+}
+
+// readObject passes the content of an object to a function
+func (d *S3fsDriver) readObject(bucket string, key string, read func(io.Reader) error) error {
+	sse, err := d.bucketServerSide(bucket)
+	if err != nil {
+		return err
+	}
+	obj, err := d.s3client.GetObject(bucket, key, getOptions(sse))
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	return read(obj)
+}
@@ -0,0 +1,97 @@
+package dockerVolumeS3
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// bucketPattern is a compiled bucket pattern, a glob or a regular expression
+type bucketPattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+// bucketPatterns parses a comma separated list of bucket patterns; patterns
+// enclosed in slashes are regular expressions, others are globs. Bucket names
+// cannot contain slashes, so a regular expression ends at the next slash and
+// may contain commas, like /^vol-[a-z]{1,3}$/.
+func bucketPatterns(value string) ([]bucketPattern, error) {
+	var patterns []bucketPattern
+	for len(value) > 0 {
+		value = strings.TrimLeft(value, " \t,")
+		if len(value) == 0 {
+			break
+		}
+		if value[0] == '/' {
+			end := strings.Index(value[1:], "/")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid bucket pattern %s: missing closing /", value)
+			}
+			expr := value[1 : end+1]
+			value = value[end+2:]
+			regex, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bucket pattern /%s/: %s", expr, err)
+			}
+			patterns = append(patterns, bucketPattern{regex: regex})
+			continue
+		}
+		glob := value
+		if end := strings.Index(value, ","); end >= 0 {
+			glob, value = value[:end], value[end+1:]
+		} else {
+			value = ""
+		}
+		glob = strings.TrimSpace(glob)
+		_, err := path.Match(glob, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket pattern %s: %s", glob, err)
+		}
+		patterns = append(patterns, bucketPattern{glob: glob})
+	}
+	return patterns, nil
+}
+
+// matchBucket checks if a bucket matches one of the patterns
+func matchBucket(patterns []bucketPattern, bucket string) bool {
+	for _, pattern := range patterns {
+		if pattern.regex != nil {
+			if pattern.regex.MatchString(bucket) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern.glob, bucket); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compileBucketFilters parses the bucket include and exclude patterns
+func (d *S3fsDriver) compileBucketFilters() error {
+	include, err := bucketPatterns(d.conf["bucketinclude"])
+	if err != nil {
+		return err
+	}
+	exclude, err := bucketPatterns(d.conf["bucketexclude"])
+	if err != nil {
+		return err
+	}
+	d.include = include
+	d.exclude = exclude
+	return nil
+}
+
+// exposed tells if a bucket may be used as a volume
+func (d *S3fsDriver) exposed(bucket string) bool {
+	if bucket == d.conf["configbucket"] || !strings.HasPrefix(bucket, d.conf["bucketprefix"]) {
+		return false
+	}
+	if len(d.include) > 0 && !matchBucket(d.include, bucket) {
+		return false
+	}
+	return !matchBucket(d.exclude, bucket)
+}
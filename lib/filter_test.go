@@ -0,0 +1,61 @@
+package dockerVolumeS3
+
+import "testing"
+
+func TestExposed(t *testing.T) {
+	tests := []struct {
+		include string
+		exclude string
+		bucket  string
+		exposed bool
+	}{
+		{"", "", "data", true},
+		{"", "", "docker-volume-s3", false},
+		{"vol-*", "", "vol-data", true},
+		{"vol-*", "", "data", false},
+		{"/^vol-[a-z]{1,3}$/", "", "vol-abc", true},
+		{"/^vol-[a-z]{1,3}$/", "", "vol-abcd", false},
+		{"/^vol-[a-z]{1,3}$/, web-*", "", "web-1", true},
+		{"web-*,/^vol-[a-z]{1,3}$/", "", "vol-ab", true},
+		{"", "tmp-*, /-(old|bak)$/", "tmp-1", false},
+		{"", "tmp-*, /-(old|bak)$/", "data-bak", false},
+		{"", "tmp-*, /-(old|bak)$/", "data", true},
+	}
+	for _, tt := range tests {
+		d := &S3fsDriver{conf: map[string]string{"configbucket": "docker-volume-s3", "bucketinclude": tt.include, "bucketexclude": tt.exclude}}
+		if err := d.compileBucketFilters(); err != nil {
+			t.Errorf("compileBucketFilters(%q, %q) failed: %s", tt.include, tt.exclude, err)
+			continue
+		}
+		if got := d.exposed(tt.bucket); got != tt.exposed {
+			t.Errorf("exposed(%q) with include %q and exclude %q = %v, want %v", tt.bucket, tt.include, tt.exclude, got, tt.exposed)
+		}
+	}
+}
+
+func TestBucketPatterns(t *testing.T) {
+	tests := []struct {
+		value string
+		count int
+		ok    bool
+	}{
+		{"", 0, true},
+		{" , ", 0, true},
+		{"a*,b*", 2, true},
+		{"/^vol-[a-z]{1,3}$/", 1, true},
+		{"/^vol-[a-z]{1,3}$/,a*", 2, true},
+		{"/^vol-[a-z/", 0, false},
+		{"/^vol-[a-z$/", 0, false},
+		{"[a-", 0, false},
+	}
+	for _, tt := range tests {
+		patterns, err := bucketPatterns(tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("bucketPatterns(%q) error = %v, want ok %v", tt.value, err, tt.ok)
+			continue
+		}
+		if len(patterns) != tt.count {
+			t.Errorf("bucketPatterns(%q) = %d patterns, want %d", tt.value, len(patterns), tt.count)
+		}
+	}
+}
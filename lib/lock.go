@@ -2,6 +2,8 @@ package dockerVolumeS3
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"strings"
@@ -15,8 +17,30 @@ const (
 	lockExt     = ".ext.lock"
 	lockWait    = 50 * time.Millisecond
 	lockTimeOut = 100
+
+	lockTokenMeta = "Lock-Token"
 )
 
+// lockTTL gets the age after which a lock is considered stale, zero if locks
+// do not expire
+func lockTTL(conf map[string]string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(conf["lockttl"])
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid lock ttl: %s", conf["lockttl"])
+	}
+	return ttl, nil
+}
+
+// lockToken generates a token identifying a lock attempt
+func lockToken() string {
+	token := make([]byte, 16)
+	_, err := rand.Read(token)
+	if err != nil {
+		return fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+	}
+	return hex.EncodeToString(token)
+}
+
 // Lock locks an object
 func (d *S3fsDriver) Lock(bucket string, object string) error {
 	log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", object).Debugf("locking object")
@@ -26,16 +50,49 @@ func (d *S3fsDriver) Lock(bucket string, object string) error {
 		log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", object).Errorf("could not get hostname: %s", err)
 		return fmt.Errorf("could not get hostname: %s", err)
 	}
+	sse, err := d.bucketServerSide(bucket)
+	if err != nil {
+		log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", object).Errorf("could not get encryption: %s", err)
+		return fmt.Errorf("could not get encryption: %s", err)
+	}
+	ttl, err := lockTTL(d.conf)
+	if err != nil {
+		return err
+	}
+	token := lockToken()
 	// loop while stat works - assume no stat means no file
+	start := time.Now()
 	count := 0
 	for {
-		_, err = d.s3client.StatObject(bucket, lock, minio.StatObjectOptions{})
+		info, err := d.s3client.StatObject(bucket, lock, statOptions(sse))
 		if err != nil {
 			log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", lock).Debugf("could not stat lock: %s", err)
-			break
+			// take the lock, then check that no other server took it meanwhile
+			reader := strings.NewReader(hostname)
+			_, err = d.s3client.PutObject(bucket, lock, reader, reader.Size(), minio.PutObjectOptions{UserMetadata: map[string]string{lockTokenMeta: token}, ServerSideEncryption: sse})
+			if err != nil {
+				log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", object).Errorf("could not put lock: %s", err)
+				return fmt.Errorf("could not put lock: %s", err)
+			}
+			time.Sleep(lockWait)
+			info, err = d.s3client.StatObject(bucket, lock, statOptions(sse))
+			if err == nil && info.Metadata.Get(userMetaPrefix+lockTokenMeta) == token {
+				break
+			}
+			log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", lock).Debugf("lock was taken by another server")
+			continue
+		}
+		// expire the locks of crashed servers
+		if ttl > 0 && time.Since(info.LastModified) > ttl {
+			log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", lock).Warnf("breaking lock older than %s", ttl)
+			err = d.s3client.RemoveObject(bucket, lock)
+			if err != nil {
+				return fmt.Errorf("could not remove stale lock: %s", err)
+			}
+			continue
 		}
 		// lock does exist
-		obj, err := d.s3client.GetObject(bucket, lock, minio.GetObjectOptions{})
+		obj, err := d.s3client.GetObject(bucket, lock, getOptions(sse))
 		if err != nil {
 			log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", lock).Errorf("could not get lock: %s", err)
 			return fmt.Errorf("could not get lock: %s", err)
@@ -49,20 +106,19 @@ func (d *S3fsDriver) Lock(bucket string, object string) error {
 		}
 		log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", lock).Debugf("lock is held by %s, waiting 50ms", buf.String())
 		// increase tried count
+		if count == 0 {
+			lockContention.Inc()
+		}
 		count++
 		if count > lockTimeOut {
+			lockTimeouts.Inc()
 			log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", lock).Errorf("lock didn't disapear for 5s")
 			return fmt.Errorf("lock didn't disapear for 5s")
 		}
 		time.Sleep(lockWait)
 	}
-	reader := strings.NewReader(hostname)
-	_, err = d.s3client.PutObject(bucket, lock, reader, reader.Size(), minio.PutObjectOptions{})
-	if err != nil {
-		log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", object).Errorf("could not put lock: %s", err)
-		return fmt.Errorf("could not put lock: %s", err)
-	}
 	// obtained the lock
+	lockWaitSeconds.Observe(time.Since(start).Seconds())
 	log.WithField("object", "minio").WithField("mehtod", "lock").WithField("bucket", bucket).WithField("object", object).Infof("locked")
 	return nil
 }
@@ -76,14 +132,19 @@ func (d *S3fsDriver) UnLock(bucket string, object string) error {
 		log.WithField("object", "minio").WithField("mehtod", "unlock").WithField("bucket", bucket).WithField("object", object).Errorf("could not get hostname: %s", err)
 		return fmt.Errorf("could not get hostname: %s", err)
 	}
+	sse, err := d.bucketServerSide(bucket)
+	if err != nil {
+		log.WithField("object", "minio").WithField("mehtod", "unlock").WithField("bucket", bucket).WithField("object", object).Errorf("could not get encryption: %s", err)
+		return fmt.Errorf("could not get encryption: %s", err)
+	}
 	// check existance of the lock
-	_, err = d.s3client.StatObject(bucket, lock, minio.StatObjectOptions{})
+	_, err = d.s3client.StatObject(bucket, lock, statOptions(sse))
 	if err != nil {
 		log.WithField("object", "minio").WithField("mehtod", "ulock").WithField("bucket", bucket).WithField("object", object).Warnf("could not stat lock: %s", err)
 		return nil
 	}
 	// lock does exist
-	obj, err := d.s3client.GetObject(bucket, lock, minio.GetObjectOptions{})
+	obj, err := d.s3client.GetObject(bucket, lock, getOptions(sse))
 	if err != nil {
 		log.WithField("object", "minio").WithField("mehtod", "unlock").WithField("bucket", bucket).WithField("object", lock).Errorf("could not get lock: %s", err)
 		return fmt.Errorf("could not get lock: %s", err)
@@ -109,3 +170,15 @@ func (d *S3fsDriver) UnLock(bucket string, object string) error {
 	log.WithField("object", "minio").WithField("mehtod", "unlock").WithField("bucket", bucket).WithField("object", object).Infof("unlocked")
 	return nil
 }
+
+// BreakLock removes a lock whatever server generated it
+func (d *S3fsDriver) BreakLock(bucket string, object string) error {
+	log.WithField("object", "minio").WithField("mehtod", "breaklock").WithField("bucket", bucket).WithField("object", object).Warnf("breaking lock")
+	lock := fmt.Sprintf("%s%s", object, lockExt)
+	err := d.s3client.RemoveObject(bucket, lock)
+	if err != nil {
+		log.WithField("object", "minio").WithField("mehtod", "breaklock").WithField("bucket", bucket).WithField("object", lock).Errorf("could not remove lock: %s", err)
+		return fmt.Errorf("could not remove lock: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package dockerVolumeS3
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	metricsNamespace = "docker_volume_s3"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Docker volume API requests by method and status.",
+	}, []string{"method", "status"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Docker volume API request latencies by method.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"method"})
+	s3RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "s3_requests_total",
+		Help:      "S3 requests by operation and status code class.",
+	}, []string{"operation", "code"})
+	s3RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "s3_request_duration_seconds",
+		Help:      "S3 request latencies by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+	s3fsRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "s3fs_restarts_total",
+		Help:      "Remounts of volumes whose s3fs process died.",
+	}, []string{"volume"})
+	lockWaitSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "lock_wait_seconds",
+		Help:      "Time waited to obtain a lock.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5},
+	})
+	lockContention = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "lock_contention_total",
+		Help:      "Lock attempts that found the lock held.",
+	})
+	lockTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "lock_timeouts_total",
+		Help:      "Lock attempts that timed out.",
+	})
+	activeMountsDesc = prometheus.NewDesc(metricsNamespace+"_active_mounts", "Containers using each mounted volume.", []string{"volume"}, nil)
+	mountHealthyDesc = prometheus.NewDesc(metricsNamespace+"_mount_healthy", "Health of each mounted volume (1 healthy, 0 unhealthy).", []string{"volume"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration, s3RequestsTotal, s3RequestDuration, s3fsRestarts, lockWaitSeconds, lockContention, lockTimeouts)
+}
+
+// observeRequest records a docker volume API request
+func observeRequest(method string, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	requestsTotal.WithLabelValues(method, status).Inc()
+	requestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// metricsDriver records metrics of the docker volume API requests
+type metricsDriver struct {
+	d *S3fsDriver
+}
+
+// WithMetrics wraps the driver to record request metrics
+func (d *S3fsDriver) WithMetrics() volume.Driver {
+	return &metricsDriver{d: d}
+}
+
+func (m *metricsDriver) Create(req *volume.CreateRequest) error {
+	start := time.Now()
+	err := m.d.Create(req)
+	observeRequest("create", start, err)
+	return err
+}
+
+func (m *metricsDriver) List() (*volume.ListResponse, error) {
+	start := time.Now()
+	resp, err := m.d.List()
+	observeRequest("list", start, err)
+	return resp, err
+}
+
+func (m *metricsDriver) Get(req *volume.GetRequest) (*volume.GetResponse, error) {
+	start := time.Now()
+	resp, err := m.d.Get(req)
+	observeRequest("get", start, err)
+	return resp, err
+}
+
+func (m *metricsDriver) Remove(req *volume.RemoveRequest) error {
+	start := time.Now()
+	err := m.d.Remove(req)
+	observeRequest("remove", start, err)
+	return err
+}
+
+func (m *metricsDriver) Path(req *volume.PathRequest) (*volume.PathResponse, error) {
+	start := time.Now()
+	resp, err := m.d.Path(req)
+	observeRequest("path", start, err)
+	return resp, err
+}
+
+func (m *metricsDriver) Mount(req *volume.MountRequest) (*volume.MountResponse, error) {
+	start := time.Now()
+	resp, err := m.d.Mount(req)
+	observeRequest("mount", start, err)
+	return resp, err
+}
+
+func (m *metricsDriver) Unmount(req *volume.UnmountRequest) error {
+	start := time.Now()
+	err := m.d.Unmount(req)
+	observeRequest("unmount", start, err)
+	return err
+}
+
+func (m *metricsDriver) Capabilities() *volume.CapabilitiesResponse {
+	start := time.Now()
+	resp := m.d.Capabilities()
+	observeRequest("capabilities", start, nil)
+	return resp
+}
+
+// mountCollector reports the state of the mounts of the driver
+type mountCollector struct {
+	d *S3fsDriver
+}
+
+func (c *mountCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeMountsDesc
+	ch <- mountHealthyDesc
+}
+
+func (c *mountCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.d.Mounts() {
+		healthy := 0.0
+		if c.d.cachedHealth(m.Mountpoint) {
+			healthy = 1
+		}
+		ch <- prometheus.MustNewConstMetric(activeMountsDesc, prometheus.GaugeValue, float64(m.Count), m.Volume)
+		ch <- prometheus.MustNewConstMetric(mountHealthyDesc, prometheus.GaugeValue, healthy, m.Volume)
+	}
+}
+
+// metricsTransport records metrics of the S3 requests
+type metricsTransport struct {
+	base     http.RoundTripper
+	endpoint string
+}
+
+// s3SubResources are the query parameters naming S3 operations
+var s3SubResources = []string{"versions", "versioning", "lifecycle", "encryption", "policy", "tagging", "uploads", "uploadId", "delete", "location", "object-lock", "retention", "legal-hold", "acl"}
+
+// s3Operation names the S3 operation of a request
+func (t *metricsTransport) s3Operation(req *http.Request) string {
+	path := strings.Trim(req.URL.Path, "/")
+	depth := 0
+	if req.URL.Host != t.endpoint {
+		// virtual host style request
+		depth = 1
+	}
+	if len(path) > 0 {
+		depth++
+		if depth == 1 && strings.Contains(path, "/") {
+			depth++
+		}
+	}
+	kind := []string{"service", "bucket", "object"}[depth]
+	if kind == "object" && req.Method == http.MethodPut && len(req.Header.Get("X-Amz-Copy-Source")) > 0 {
+		return "copy_object"
+	}
+	op := strings.ToLower(req.Method) + "_" + kind
+	query := req.URL.Query()
+	for _, sub := range s3SubResources {
+		if _, ok := query[sub]; ok {
+			return op + "_" + strings.ToLower(strings.ReplaceAll(sub, "-", "_"))
+		}
+	}
+	return op
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op := t.s3Operation(req)
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	s3RequestDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	code := "error"
+	if err == nil {
+		code = fmt.Sprintf("%dxx", resp.StatusCode/100)
+	}
+	s3RequestsTotal.WithLabelValues(op, code).Inc()
+	return resp, err
+}
+
+// ServeMetrics serves the prometheus metrics on S3_CONF_METRICSADDRESS
+func (d *S3fsDriver) ServeMetrics() error {
+	address := d.conf["metricsaddress"]
+	if len(address) == 0 {
+		return nil
+	}
+	err := prometheus.Register(&mountCollector{d: d})
+	if err != nil {
+		return fmt.Errorf("could not register mount metrics: %s", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.WithField("command", "metrics").Infof("metrics listening on %s", address)
+	return http.ListenAndServe(address, mux)
+}
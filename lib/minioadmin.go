@@ -0,0 +1,48 @@
+package dockerVolumeS3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v6/pkg/signer"
+)
+
+const (
+	minioAdminPrefix = "/minio/admin/v3"
+)
+
+// minioAdmin sends a signed request to the MinIO admin API
+func (d *S3fsDriver) minioAdmin(method string, path string, query url.Values, body []byte) ([]byte, error) {
+	u, err := url.Parse(d.conf["endpoint"])
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + minioAdminPrefix + path
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	req = signer.SignV4(*req, d.conf["accesskey"], d.conf["secretkey"], "", d.conf["region"])
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not query minio admin api: %s", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read minio admin api response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("minio admin api %s returned %s: %s", path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
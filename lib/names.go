@@ -0,0 +1,100 @@
+package dockerVolumeS3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"regexp"
+	"strings"
+)
+
+const (
+	minBucketLength = 3
+	maxBucketLength = 63
+	bucketHashSize  = 8
+)
+
+var (
+	validBucket   = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*[a-z0-9]$`)
+	invalidBucket = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// isValidBucket checks the S3 naming rules of a bucket
+func isValidBucket(bucket string) bool {
+	if len(bucket) < minBucketLength || len(bucket) > maxBucketLength || !validBucket.MatchString(bucket) {
+		return false
+	}
+	if strings.Contains(bucket, "..") || strings.Contains(bucket, ".-") || strings.Contains(bucket, "-.") {
+		return false
+	}
+	return net.ParseIP(bucket) == nil
+}
+
+// bucketName maps a volume name to its bucket: valid bucket names are kept,
+// others are sanitized and get a hash of the volume name so that different
+// volumes never share a bucket. Volumes are mapped back with the registry or,
+// for valid names, by removing the prefix.
+func (d *S3fsDriver) bucketName(name string) string {
+	bucket := d.conf["bucketprefix"] + name
+	if isValidBucket(bucket) {
+		return bucket
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:bucketHashSize]
+	bucket = strings.Trim(invalidBucket.ReplaceAllString(strings.ToLower(bucket), "-"), "-")
+	if len(bucket) > maxBucketLength-bucketHashSize-1 {
+		bucket = strings.TrimRight(bucket[:maxBucketLength-bucketHashSize-1], "-")
+	}
+	if len(bucket) == 0 {
+		return hash
+	}
+	return bucket + "-" + hash
+}
+
+// volumeName maps a bucket not in the registry back to its volume
+func (d *S3fsDriver) volumeName(bucket string) (string, bool) {
+	name := strings.TrimPrefix(bucket, d.conf["bucketprefix"])
+	return name, d.bucketName(name) == bucket
+}
+
+// legacyBucketName maps a volume name to its bucket like
+// S3_CONF_REPLACEUNDERSCORES did before bucket names got a hash, empty if the
+// legacy mapping is disabled with false or does not apply
+func (d *S3fsDriver) legacyBucketName(name string) string {
+	if d.conf["replaceunderscores"] == "false" || !strings.Contains(name, "_") {
+		return ""
+	}
+	bucket := d.conf["bucketprefix"] + strings.ReplaceAll(name, "_", "-")
+	if !isValidBucket(bucket) {
+		return ""
+	}
+	return bucket
+}
+
+// unregisteredBucket gets the bucket of a volume missing in the registry and
+// tells if it exists, falling back to the legacy mapping of underscores
+func (d *S3fsDriver) unregisteredBucket(name string) (string, bool, error) {
+	bucket := d.bucketName(name)
+	exists, err := d.bucketExists(bucket)
+	if err != nil || exists {
+		return bucket, exists, err
+	}
+	if legacy := d.legacyBucketName(name); len(legacy) > 0 {
+		exists, err := d.bucketExists(legacy)
+		if err != nil || exists {
+			return legacy, exists, err
+		}
+	}
+	return bucket, false, nil
+}
+
+// bucketOwner gets the registered volume other than name using a bucket,
+// empty if the bucket is free
+func bucketOwner(vols map[string]*VolConfig, name string, bucket string) string {
+	for _, vol := range vols {
+		if vol.Name != name && vol.Bucket == bucket {
+			return vol.Name
+		}
+	}
+	return ""
+}
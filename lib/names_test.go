@@ -0,0 +1,154 @@
+package dockerVolumeS3
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsValidBucket(t *testing.T) {
+	tests := []struct {
+		bucket string
+		valid  bool
+	}{
+		{"my-volume", true},
+		{"my.volume", true},
+		{"abc", true},
+		{"ab", false},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), false},
+		{"My-Volume", false},
+		{"my_volume", false},
+		{"-volume", false},
+		{"volume-", false},
+		{"my..volume", false},
+		{"my.-volume", false},
+		{"my-.volume", false},
+		{"192.168.1.1", false},
+	}
+	for _, tt := range tests {
+		if got := isValidBucket(tt.bucket); got != tt.valid {
+			t.Errorf("isValidBucket(%q) = %v, want %v", tt.bucket, got, tt.valid)
+		}
+	}
+}
+
+func TestBucketName(t *testing.T) {
+	tests := []struct {
+		prefix string
+		name   string
+		bucket string
+	}{
+		{"", "my-volume", "my-volume"},
+		{"dockervol-", "data", "dockervol-data"},
+		{"", "my_volume", "my-volume-"},
+		{"", "My-Volume", "my-volume-"},
+		{"", "ab", ""},
+		{"", "__", ""},
+		{"", strings.Repeat("a", 70), strings.Repeat("a", 54) + "-"},
+	}
+	for _, tt := range tests {
+		d := &S3fsDriver{conf: map[string]string{"bucketprefix": tt.prefix}}
+		bucket := d.bucketName(tt.name)
+		if !isValidBucket(bucket) {
+			t.Errorf("bucketName(%q) = %q is not a valid bucket", tt.name, bucket)
+		}
+		if !strings.HasPrefix(bucket, tt.bucket) {
+			t.Errorf("bucketName(%q) = %q, want prefix %q", tt.name, bucket, tt.bucket)
+		}
+		if isValidBucket(tt.prefix+tt.name) && bucket != tt.prefix+tt.name {
+			t.Errorf("bucketName(%q) = %q, want the valid name unchanged", tt.name, bucket)
+		}
+	}
+}
+
+func TestBucketNameCollisions(t *testing.T) {
+	d := &S3fsDriver{conf: map[string]string{}}
+	names := []string{
+		"my-volume", "my_volume", "My_Volume", "MY-VOLUME", "my.volume", "my..volume",
+		"my--volume", "my volume", "-my-volume-", "ab", "AB", "__",
+		strings.Repeat("a", 63), strings.Repeat("a", 64), strings.Repeat("a", 65),
+	}
+	buckets := make(map[string]string)
+	for _, name := range names {
+		bucket := d.bucketName(name)
+		if other, ok := buckets[bucket]; ok {
+			t.Errorf("volumes %q and %q share bucket %q", other, name, bucket)
+		}
+		buckets[bucket] = name
+	}
+}
+
+func TestVolumeName(t *testing.T) {
+	tests := []struct {
+		prefix string
+		bucket string
+		name   string
+		ok     bool
+	}{
+		{"", "my-volume", "my-volume", true},
+		{"dockervol-", "dockervol-data", "data", true},
+		{"dockervol-", "other-data", "other-data", false},
+		{"", "my-volume-0123abcd", "my-volume-0123abcd", true},
+	}
+	for _, tt := range tests {
+		d := &S3fsDriver{conf: map[string]string{"bucketprefix": tt.prefix}}
+		name, ok := d.volumeName(tt.bucket)
+		if name != tt.name || ok != tt.ok {
+			t.Errorf("volumeName(%q) = %q, %v, want %q, %v", tt.bucket, name, ok, tt.name, tt.ok)
+		}
+		if ok && d.bucketName(name) != tt.bucket {
+			t.Errorf("bucketName(volumeName(%q)) = %q", tt.bucket, d.bucketName(name))
+		}
+	}
+	// sanitized names are only found through the registry
+	d := &S3fsDriver{conf: map[string]string{}}
+	if name, _ := d.volumeName(d.bucketName("my_volume")); name == "my_volume" {
+		t.Errorf("volumeName maps a sanitized bucket back to its volume")
+	}
+}
+
+func TestLegacyBucketName(t *testing.T) {
+	tests := []struct {
+		replace string
+		prefix  string
+		name    string
+		bucket  string
+	}{
+		{"true", "", "my_volume", "my-volume"},
+		{"true", "dockervol-", "my_volume", "dockervol-my-volume"},
+		{"true", "", "my-volume", ""},
+		{"true", "", "My_Volume", ""},
+		{"false", "", "my_volume", ""},
+		{"", "", "my_volume", "my-volume"},
+	}
+	for _, tt := range tests {
+		d := &S3fsDriver{conf: map[string]string{"replaceunderscores": tt.replace, "bucketprefix": tt.prefix}}
+		if got := d.legacyBucketName(tt.name); got != tt.bucket {
+			t.Errorf("legacyBucketName(%q) = %q, want %q", tt.name, got, tt.bucket)
+		}
+	}
+}
+
+func TestBucketOwner(t *testing.T) {
+	d := &S3fsDriver{conf: map[string]string{}}
+	vols := map[string]*VolConfig{
+		"my_volume": {Name: "my_volume", Bucket: d.bucketName("my_volume")},
+		"old_data":  {Name: "old_data", Bucket: "old-data"},
+		"web":       {Name: "web", Bucket: "web"},
+	}
+	tests := []struct {
+		name  string
+		owner string
+	}{
+		{d.bucketName("my_volume"), "my_volume"},
+		{"my_volume", ""},
+		{"old-data", "old_data"},
+		{"web", ""},
+		{"data", ""},
+	}
+	for _, tt := range tests {
+		if got := bucketOwner(vols, tt.name, d.bucketName(tt.name)); got != tt.owner {
+			t.Errorf("bucketOwner(%q) = %q, want %q", tt.name, got, tt.owner)
+		}
+	}
+}
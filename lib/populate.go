@@ -0,0 +1,52 @@
+package dockerVolumeS3
+
+import (
+	"fmt"
+)
+
+// populateSources are the create options filling a new volume
+var populateSources = []string{"restore_from", "from_snapshot", "from", "seed"}
+
+// populateSource gets the create option filling a new volume, if any
+func populateSource(options map[string]string) (string, error) {
+	source := ""
+	for _, s := range populateSources {
+		if len(options[s]) == 0 {
+			continue
+		}
+		if len(source) > 0 {
+			return "", fmt.Errorf("options %s and %s are exclusive", source, s)
+		}
+		source = s
+	}
+	return source, nil
+}
+
+// resumablePopulate tells if a source can resume filling an existing bucket
+func resumablePopulate(source string) bool {
+	return source == "from"
+}
+
+// populateVolume fills a new bucket from the source given in the options
+func (d *S3fsDriver) populateVolume(bucket string, options map[string]string) error {
+	source, err := populateSource(options)
+	if err != nil {
+		return err
+	}
+	// the volume is not registered yet
+	sse, err := d.serverSide(&VolConfig{Options: options})
+	if err != nil {
+		return err
+	}
+	switch source {
+	case "restore_from":
+		return d.restoreVolume(options["restore_from"], bucket, options["restore_at"], sse)
+	case "from_snapshot":
+		return d.restoreSnapshot(options["from_snapshot"], bucket, sse)
+	case "from":
+		return d.cloneVolume(options["from"], bucket, sse)
+	case "seed":
+		return d.seedBucket(options["seed"], bucket, sse)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package dockerVolumeS3
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyConfig gets the proxy settings from the proxy and noproxy
+// configuration, falling back to HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+func (d *S3fsDriver) proxyConfig() *httpproxy.Config {
+	config := httpproxy.FromEnvironment()
+	if proxy := d.conf["proxy"]; len(proxy) > 0 {
+		config.HTTPProxy = proxy
+		config.HTTPSProxy = proxy
+	}
+	if noProxy := d.conf["noproxy"]; len(noProxy) > 0 {
+		config.NoProxy = noProxy
+	}
+	return config
+}
+
+// checkProxy validates the proxy url
+func checkProxy(proxy string) error {
+	if len(proxy) == 0 {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy %s: %s", proxy, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return nil
+	}
+	return fmt.Errorf("invalid proxy %s: scheme must be http, https or socks5", proxy)
+}
+
+// proxyFunc selects the proxy of a request
+func (d *S3fsDriver) proxyFunc() func(*http.Request) (*url.URL, error) {
+	proxy := d.proxyConfig().ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+// proxyEnv gets the proxy environment of the mount commands; curl reads the
+// lower case variables and rclone the upper case ones
+func (d *S3fsDriver) proxyEnv() []string {
+	config := d.proxyConfig()
+	var env []string
+	for _, v := range []struct{ name, value string }{
+		{"http_proxy", config.HTTPProxy},
+		{"https_proxy", config.HTTPSProxy},
+		{"no_proxy", config.NoProxy},
+	} {
+		if len(v.value) > 0 {
+			env = append(env, v.name+"="+v.value, strings.ToUpper(v.name)+"="+v.value)
+		}
+	}
+	return env
+}
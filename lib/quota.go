@@ -0,0 +1,179 @@
+package dockerVolumeS3
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	quotaReadOnly = "readonly"
+	quotaRefuse   = "refuse"
+)
+
+// sizeUnits are the multipliers of the size units
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// parseSize parses a size with an optional unit like 50GiB or 10GB
+func parseSize(size string) (int64, error) {
+	size = strings.TrimSpace(size)
+	i := len(size)
+	for i > 0 && (size[i-1] < '0' || size[i-1] > '9') {
+		i--
+	}
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(size[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %s", size)
+	}
+	n, err := strconv.ParseInt(size[:i], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	if n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("size %s is too large", size)
+	}
+	return n * unit, nil
+}
+
+// checkQuotaOptions validates the quota options of a volume
+func checkQuotaOptions(options map[string]string) error {
+	if len(options["quota"]) > 0 {
+		_, err := parseSize(options["quota"])
+		if err != nil {
+			return err
+		}
+	}
+	if len(options["quota_soft"]) > 0 {
+		soft, err := strconv.Atoi(options["quota_soft"])
+		if err != nil || soft < 1 || soft > 100 {
+			return fmt.Errorf("invalid soft quota percentage %s", options["quota_soft"])
+		}
+	}
+	switch options["quota_action"] {
+	case "", quotaReadOnly, quotaRefuse:
+		return nil
+	}
+	return fmt.Errorf("unknown quota action %s", options["quota_action"])
+}
+
+// isOverQuota tells if a volume reached its hard quota
+func (d *S3fsDriver) isOverQuota(name string) bool {
+	d.usageLock.Lock()
+	defer d.usageLock.Unlock()
+	return d.overQuota[name]
+}
+
+// quotaAction gets the action taken when a volume reaches its hard quota
+func (d *S3fsDriver) quotaAction(name string) string {
+	vol, err := d.getVolume(name)
+	if err != nil {
+		log.WithField("command", "quota").Warnf("could not get volume %s: %s", name, err)
+	}
+	return d.volumeOption(vol, "quota_action")
+}
+
+// enforceQuota compares the usage of a volume to its quota
+func (d *S3fsDriver) enforceQuota(name string, usage VolumeUsage) {
+	vol, err := d.getVolume(name)
+	if err != nil {
+		log.WithField("command", "quota").Warnf("could not get volume %s: %s", name, err)
+		return
+	}
+	over := false
+	if quota := d.volumeOption(vol, "quota"); len(quota) > 0 {
+		limit, err := parseSize(quota)
+		if err != nil {
+			log.WithField("command", "quota").Warnf("invalid quota of volume %s: %s", name, err)
+			return
+		}
+		soft, err := strconv.Atoi(d.volumeOption(vol, "quota_soft"))
+		if err != nil {
+			soft = 100
+		}
+		over = usage.Bytes >= limit
+		if !over && usage.Bytes >= limit/100*int64(soft) {
+			log.WithField("command", "quota").Warnf("volume %s uses %d of %d bytes", name, usage.Bytes, limit)
+		}
+	}
+	d.usageLock.Lock()
+	changed := d.overQuota[name] != over
+	d.overQuota[name] = over
+	d.usageLock.Unlock()
+	if !changed {
+		return
+	}
+	if over {
+		log.WithField("command", "quota").Errorf("volume %s reached its quota", name)
+	} else {
+		log.WithField("command", "quota").Infof("volume %s is below its quota", name)
+	}
+	if d.volumeOption(vol, "quota_action") == quotaReadOnly {
+		err = d.remount(name, over)
+		if err != nil {
+			log.WithField("command", "quota").Errorf("could not remount volume %s: %s", name, err)
+		}
+	}
+}
+
+// remount mounts an active volume again, read only or read write. Running
+// containers keep the bind mount of the previous mount and their access: the
+// new mount only applies to containers started afterwards, and new mounts of
+// a volume over its quota are refused while it is still mounted read write.
+// Only a MinIO bucket quota (minioquota) stops the writes of running
+// containers.
+func (d *S3fsDriver) remount(name string, readonly bool) error {
+	d.mountsLock.Lock()
+	count := d.mounts[name]
+	d.mountsLock.Unlock()
+	if count == 0 {
+		return nil
+	}
+	bucket, err := d.volumeBucket(name)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/%s", d.conf["rootmount"], name)
+	log.WithField("command", "quota").Infof("remounting volume %s (read only: %v)", name, readonly)
+	// the unmount may wait for the mount to be released
+	err = d.unmount(path)
+	if err != nil {
+		return err
+	}
+	d.mountsLock.Lock()
+	defer d.mountsLock.Unlock()
+	// the volume was unmounted or mounted again meanwhile
+	if d.mounts[name] == 0 || isMounted(path) {
+		return nil
+	}
+	return d.mountBucket(name, bucket, path, readonly)
+}
+
+// setMinioQuota sets a hard bucket quota with the MinIO admin API
+func (d *S3fsDriver) setMinioQuota(bucket string, quota string) error {
+	limit, err := parseSize(quota)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{"quota": limit, "quotatype": "hard"})
+	if err != nil {
+		return err
+	}
+	_, err = d.minioAdmin("PUT", "/set-bucket-quota", url.Values{"bucket": {bucket}}, body)
+	return err
+}
@@ -0,0 +1,59 @@
+package dockerVolumeS3
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size  string
+		bytes int64
+		ok    bool
+	}{
+		{"1024", 1024, true},
+		{"10B", 10, true},
+		{"50GiB", 50 << 30, true},
+		{"10GB", 10 * 1000 * 1000 * 1000, true},
+		{" 2 tib ", 2 << 40, true},
+		{"0", 0, true},
+		{"8388607TiB", 8388607 << 40, true},
+		{"8388608TiB", 0, false},
+		{"9223372036854775807", 9223372036854775807, true},
+		{"9223372036854775808", 0, false},
+		{"10PB", 0, false},
+		{"GiB", 0, false},
+		{"-1GiB", 0, false},
+		{"1.5GiB", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		bytes, err := parseSize(tt.size)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseSize(%q) error = %v, want ok %v", tt.size, err, tt.ok)
+			continue
+		}
+		if tt.ok && bytes != tt.bytes {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.size, bytes, tt.bytes)
+		}
+	}
+}
+
+func TestCheckQuotaOptions(t *testing.T) {
+	tests := []struct {
+		options map[string]string
+		ok      bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{"quota": "50GiB", "quota_soft": "90", "quota_action": "readonly"}, true},
+		{map[string]string{"quota": "1TB", "quota_action": "refuse"}, true},
+		{map[string]string{"quota": "50XB"}, false},
+		{map[string]string{"quota": "100000000TiB"}, false},
+		{map[string]string{"quota_soft": "0"}, false},
+		{map[string]string{"quota_soft": "101"}, false},
+		{map[string]string{"quota_soft": "high"}, false},
+		{map[string]string{"quota_action": "delete"}, false},
+	}
+	for _, tt := range tests {
+		if err := checkQuotaOptions(tt.options); (err == nil) != tt.ok {
+			t.Errorf("checkQuotaOptions(%v) = %v, want ok %v", tt.options, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package dockerVolumeS3
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	mountsPrefix = "mounts/"
+)
+
+// loadVolumes gets the volume registry, from the cache if it is fresh
+func (d *S3fsDriver) loadVolumes() (map[string]*VolConfig, error) {
+	if vols, ok := d.cachedVolumes(); ok {
+		return vols, nil
+	}
+	vols, err := d.readVolumes()
+	if err != nil {
+		return nil, err
+	}
+	d.cacheVolumes(vols)
+	return vols, nil
+}
+
+// readVolumes reads the volume registry from the configuration bucket
+func (d *S3fsDriver) readVolumes() (map[string]*VolConfig, error) {
+	vols := make(map[string]*VolConfig)
+	bucket := d.conf["configbucket"]
+	err := d.createBucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	sse, err := d.serverSide(nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.s3client.StatObject(bucket, configObject, statOptions(sse))
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return vols, nil
+		}
+		log.WithField("command", "registry").Errorf("could not stat volume registry: %s", err)
+		return nil, fmt.Errorf("could not stat volume registry: %s", err)
+	}
+	obj, err := d.s3client.GetObject(bucket, configObject, getOptions(sse))
+	if err != nil {
+		log.WithField("command", "registry").Errorf("could not get volume registry: %s", err)
+		return nil, fmt.Errorf("could not get volume registry: %s", err)
+	}
+	defer obj.Close()
+	buf := bytes.Buffer{}
+	_, err = buf.ReadFrom(obj)
+	if err != nil {
+		log.WithField("command", "registry").Errorf("could not read volume registry: %s", err)
+		return nil, fmt.Errorf("could not read volume registry: %s", err)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		vol, err := parseRegistryLine(line)
+		if err != nil {
+			log.WithField("command", "registry").Warnf("ignoring %s", err)
+			continue
+		}
+		vols[vol.Name] = vol
+	}
+	return vols, nil
+}
+
+// registryLine formats a volume as a registry line. Every option is written
+// as key=value so that empty and false values are kept.
+func registryLine(vol *VolConfig) string {
+	var keys []string
+	for k := range vol.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var options []string
+	for _, k := range keys {
+		options = append(options, k+"="+vol.Options[k])
+	}
+	line := fmt.Sprintf("%s;%s;%s", vol.Name, vol.Bucket, strings.Join(options, ","))
+	if !vol.Created.IsZero() {
+		line += ";" + vol.Created.UTC().Format(time.RFC3339)
+	}
+	return line
+}
+
+// parseRegistryLine parses a registry line, options without value are flags
+// set to true
+func parseRegistryLine(line string) (*VolConfig, error) {
+	// the creation date is optional
+	created := time.Time{}
+	if i := strings.LastIndex(line, ";"); i >= 0 {
+		t, err := time.Parse(time.RFC3339, line[i+1:])
+		if err == nil {
+			created = t
+			line = line[:i]
+		}
+	}
+	infos := strings.SplitN(line, ";", 3)
+	if len(infos) != 3 {
+		return nil, fmt.Errorf("invalid registry line: %s", line)
+	}
+	options := make(map[string]string)
+	for _, o := range strings.Split(infos[2], ",") {
+		if len(o) == 0 {
+			continue
+		}
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) == 1 {
+			options[kv[0]] = "true"
+			continue
+		}
+		options[kv[0]] = kv[1]
+	}
+	return &VolConfig{Name: infos[0], Bucket: infos[1], Options: options, Created: created}, nil
+}
+
+// saveVolumes writes the volume registry to the configuration bucket
+func (d *S3fsDriver) saveVolumes(vols map[string]*VolConfig) error {
+	var names []string
+	for name := range vols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	content := emptyVolume
+	for _, name := range names {
+		content += registryLine(vols[name]) + "\n"
+	}
+	sse, err := d.serverSide(nil)
+	if err != nil {
+		return err
+	}
+	reader := strings.NewReader(content)
+	_, err = d.s3client.PutObject(d.conf["configbucket"], configObject, reader, reader.Size(), minio.PutObjectOptions{ContentType: "text/plain", ServerSideEncryption: sse})
+	if err != nil {
+		log.WithField("command", "registry").Errorf("could not save volume registry: %s", err)
+		return fmt.Errorf("could not save volume registry: %s", err)
+	}
+	return nil
+}
+
+// getVolume gets a volume from the registry, nil if it is not registered
+func (d *S3fsDriver) getVolume(name string) (*VolConfig, error) {
+	vols, err := d.loadVolumes()
+	if err != nil {
+		return nil, err
+	}
+	return vols[name], nil
+}
+
+// registerVolume adds or updates a volume in the registry
+func (d *S3fsDriver) registerVolume(vol *VolConfig) error {
+	return d.updateVolumes(func(vols map[string]*VolConfig) {
+		if old, ok := vols[vol.Name]; ok && vol.Created.IsZero() {
+			vol.Created = old.Created
+		}
+		if vol.Created.IsZero() {
+			vol.Created = time.Now()
+		}
+		vols[vol.Name] = vol
+	})
+}
+
+// deregisterVolume removes a volume from the registry
+func (d *S3fsDriver) deregisterVolume(name string) error {
+	return d.updateVolumes(func(vols map[string]*VolConfig) {
+		delete(vols, name)
+	})
+}
+
+// updateVolumes modifies the registry while holding its lock
+func (d *S3fsDriver) updateVolumes(update func(map[string]*VolConfig)) error {
+	bucket := d.conf["configbucket"]
+	err := d.createBucket(bucket)
+	if err != nil {
+		return err
+	}
+	err = d.Lock(bucket, configObject)
+	if err != nil {
+		return err
+	}
+	defer d.UnLock(bucket, configObject)
+	vols, err := d.readVolumes()
+	if err != nil {
+		return err
+	}
+	update(vols)
+	err = d.saveVolumes(vols)
+	if err != nil {
+		return err
+	}
+	d.cacheVolumes(vols)
+	return nil
+}
+
+// volumeBucket gets the bucket of an existing volume
+func (d *S3fsDriver) volumeBucket(name string) (string, error) {
+	vol, err := d.getVolume(name)
+	if err != nil {
+		return "", err
+	}
+	if vol != nil {
+		return vol.Bucket, nil
+	}
+	bucket, exists, err := d.unregisteredBucket(name)
+	if err != nil {
+		return "", fmt.Errorf("could not check existance of bucket %s: %s", bucket, err)
+	}
+	if !exists || !d.exposed(bucket) {
+		return "", fmt.Errorf("volume %s not found", name)
+	}
+	return bucket, nil
+}
+
+// volumeOption gets a volume option, falling back to the global configuration
+func (d *S3fsDriver) volumeOption(vol *VolConfig, key string) string {
+	if vol != nil {
+		if value, ok := vol.Options[key]; ok {
+			return value
+		}
+	}
+	return d.conf[key]
+}
+
+// markMounted records that this host mounts a volume
+func (d *S3fsDriver) markMounted(name string) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("could not get hostname: %s", err)
+	}
+	sse, err := d.serverSide(nil)
+	if err != nil {
+		return err
+	}
+	reader := strings.NewReader(hostname)
+	_, err = d.s3client.PutObject(d.conf["configbucket"], mountsPrefix+name+"/"+hostname, reader, reader.Size(), minio.PutObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		return fmt.Errorf("could not put mount marker: %s", err)
+	}
+	return nil
+}
+
+// unmarkMounted removes the record that this host mounts a volume
+func (d *S3fsDriver) unmarkMounted(name string) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("could not get hostname: %s", err)
+	}
+	err = d.s3client.RemoveObject(d.conf["configbucket"], mountsPrefix+name+"/"+hostname)
+	if err != nil {
+		return fmt.Errorf("could not remove mount marker: %s", err)
+	}
+	return nil
+}
+
+// mountMarkerTTL gets how long a mount marker that is not refreshed is
+// trusted, zero if markers do not expire
+func mountMarkerTTL(conf map[string]string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(conf["mountmarkerttl"])
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid mount marker ttl: %s", conf["mountmarkerttl"])
+	}
+	return ttl, nil
+}
+
+// mountHosts lists the hosts mounting a volume. The marker of this host
+// counts only if the volume is mounted here, markers of other hosts only
+// until they expire
+func (d *S3fsDriver) mountHosts(name string) ([]string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("could not get hostname: %s", err)
+	}
+	ttl, err := mountMarkerTTL(d.conf)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	prefix := mountsPrefix + name + "/"
+	for object := range d.s3client.ListObjects(d.conf["configbucket"], prefix, true, nil) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("could not list mount markers: %s", object.Err)
+		}
+		host := strings.TrimPrefix(object.Key, prefix)
+		if host == hostname {
+			if isMounted(fmt.Sprintf("%s/%s", d.conf["rootmount"], name)) {
+				hosts = append(hosts, host)
+				continue
+			}
+			log.WithField("command", "driver").WithField("method", "mountHosts").Warnf("removing stale mount marker of volume %s on this host", name)
+			err = d.unmarkMounted(name)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if ttl > 0 && time.Since(object.LastModified) > ttl {
+			log.WithField("command", "driver").WithField("method", "mountHosts").Warnf("ignoring expired mount marker of volume %s on %s", name, host)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// RefreshMountMarkers periodically refreshes the mount markers of the volumes
+// mounted on this host so that they do not expire
+func (d *S3fsDriver) RefreshMountMarkers() {
+	ttl, err := mountMarkerTTL(d.conf)
+	if err != nil || ttl == 0 {
+		return
+	}
+	for {
+		time.Sleep(ttl / 3)
+		d.mountsLock.Lock()
+		var names []string
+		for name, count := range d.mounts {
+			if count > 0 {
+				names = append(names, name)
+			}
+		}
+		d.mountsLock.Unlock()
+		for _, name := range names {
+			err := d.markMounted(name)
+			if err != nil {
+				log.WithField("command", "driver").WithField("method", "refreshMountMarkers").Warnf("could not refresh mount marker of volume %s: %s", name, err)
+			}
+		}
+	}
+}
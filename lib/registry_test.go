@@ -0,0 +1,52 @@
+package dockerVolumeS3
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistryLineRoundTrip(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	tests := []*VolConfig{
+		{Name: "data", Bucket: "data", Options: map[string]string{}},
+		{Name: "data", Bucket: "dockervol-data", Options: map[string]string{"versioning": "true"}, Created: created},
+		{Name: "my_volume", Bucket: "my-volume-9c8f285e", Options: map[string]string{"force_remove": "false", "archive_bucket": ""}},
+		{Name: "web", Bucket: "web", Options: map[string]string{"seed": "https://example.com/web.tar.gz?v=1", "tag.owner": "alice", "quota": "50GiB"}, Created: created},
+	}
+	for _, vol := range tests {
+		line := registryLine(vol)
+		got, err := parseRegistryLine(line)
+		if err != nil {
+			t.Errorf("parseRegistryLine(%q) failed: %s", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, vol) {
+			t.Errorf("parseRegistryLine(%q) = %+v, want %+v", line, got, vol)
+		}
+	}
+}
+
+func TestParseRegistryLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		options map[string]string
+		ok      bool
+	}{
+		// lines of the previous format write true options as flags
+		{"data;data;nonempty,versioning", map[string]string{"nonempty": "true", "versioning": "true"}, true},
+		{"data;data;", map[string]string{}, true},
+		{"data;data;quota=1GiB;2024-05-01T12:30:00Z", map[string]string{"quota": "1GiB"}, true},
+		{"data;data", nil, false},
+	}
+	for _, tt := range tests {
+		vol, err := parseRegistryLine(tt.line)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseRegistryLine(%q) error = %v, want ok %v", tt.line, err, tt.ok)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(vol.Options, tt.options) {
+			t.Errorf("parseRegistryLine(%q) options = %v, want %v", tt.line, vol.Options, tt.options)
+		}
+	}
+}
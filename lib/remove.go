@@ -0,0 +1,283 @@
+package dockerVolumeS3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	removeDelete  = "delete"
+	removeRetain  = "retain"
+	removeArchive = "archive"
+
+	maxReportedErrors = 10
+
+	archiveRuleID = "docker-volume-s3-archive-"
+	archiveRule   = `<ID>%s</ID><Filter><Prefix>%s</Prefix></Filter><Status>Enabled</Status><Expiration><Days>%s</Days></Expiration>`
+)
+
+// checkRemovePolicy validates a remove policy
+func checkRemovePolicy(policy string) error {
+	switch policy {
+	case "", removeDelete, removeRetain, removeArchive:
+		return nil
+	}
+	return fmt.Errorf("unknown remove policy: %s", policy)
+}
+
+// deleteBucket empties and removes a bucket, including object versions, delete
+// markers and incomplete multipart uploads
+func (d *S3fsDriver) deleteBucket(bucket string) error {
+	log.WithField("command", "driver").WithField("method", "remove").Infof("removing bucket: %s", bucket)
+	var errs []string
+	// abort incomplete multipart uploads
+	aborted := make(map[string]bool)
+	for upload := range d.s3client.ListIncompleteUploads(bucket, "", true, nil) {
+		if upload.Err != nil {
+			errs = append(errs, fmt.Sprintf("could not list incomplete uploads: %s", upload.Err))
+			break
+		}
+		if aborted[upload.Key] {
+			continue
+		}
+		aborted[upload.Key] = true
+		err := d.s3client.RemoveIncompleteUpload(bucket, upload.Key)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: could not abort upload: %s", upload.Key, err))
+		}
+	}
+	versioned, versionErr := d.isVersioned(bucket)
+	var versions []objectVersion
+	if versioned || versionErr != nil {
+		// listing versions also finds the objects of unversioned buckets
+		var err error
+		versions, err = d.listObjectVersions(bucket, "")
+		if err != nil {
+			if versioned {
+				errs = append(errs, err.Error())
+			}
+			versioned = false
+		} else {
+			versioned = true
+		}
+	}
+	if versioned {
+		// remove every version and delete marker
+		errs = append(errs, d.removeObjectVersions(bucket, versions)...)
+	} else {
+		// channel of objects to remove
+		objectsCh := make(chan string)
+		var listErr error
+		// Send object names that are needed to be removed to objectsCh
+		go func() {
+			defer close(objectsCh)
+			// List all objects from a bucket
+			for object := range d.s3client.ListObjects(bucket, "", true, nil) {
+				if object.Err != nil {
+					listErr = object.Err
+					break
+				}
+				objectsCh <- object.Key
+			}
+		}()
+		// remove the obtained objects from channel
+		for rErr := range d.s3client.RemoveObjects(bucket, objectsCh) {
+			errs = append(errs, fmt.Sprintf("%s: %s", rErr.ObjectName, rErr.Err))
+		}
+		if listErr != nil {
+			errs = append(errs, fmt.Sprintf("could not list objects: %s", listErr))
+		}
+	}
+	if len(errs) > 0 && versionErr != nil {
+		// the versioning state only matters when the bucket was not emptied
+		errs = append(errs, versionErr.Error())
+	}
+	if len(errs) > 0 {
+		log.WithField("command", "driver").WithField("method", "remove").Errorf("could not empty bucket '%s': %s", bucket, strings.Join(errs, "; "))
+		return fmt.Errorf("could not empty bucket '%s': %d errors: %s", bucket, len(errs), summarizeErrors(errs))
+	}
+	// remove bucket
+	err := d.s3client.RemoveBucket(bucket)
+	d.invalidateCache()
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "remove").Errorf("could not remove bucket: %s", err)
+		return fmt.Errorf("could not remove bucket: %s", err)
+	}
+	return nil
+}
+
+// summarizeErrors joins the first errors of a list
+func summarizeErrors(errs []string) string {
+	if len(errs) <= maxReportedErrors {
+		return strings.Join(errs, "; ")
+	}
+	return fmt.Sprintf("%s; and %d more", strings.Join(errs[:maxReportedErrors], "; "), len(errs)-maxReportedErrors)
+}
+
+// archiveBucket copies the objects of a bucket to a timestamped prefix of the
+// archive bucket
+func (d *S3fsDriver) archiveBucket(vol *VolConfig, name string, bucket string) error {
+	archive, archivePrefix, err := d.archiveTarget(vol)
+	if err != nil {
+		return err
+	}
+	if archive == bucket {
+		return fmt.Errorf("cannot archive bucket %s into itself", bucket)
+	}
+	err = d.createBucket(archive)
+	if err != nil {
+		return err
+	}
+	prefix := fmt.Sprintf("%s%s/%s/", archivePrefix, name, time.Now().UTC().Format("20060102T150405Z"))
+	log.WithField("command", "driver").WithField("method", "remove").Infof("archiving bucket %s to %s/%s", bucket, archive, prefix)
+	sse, err := d.bucketServerSide(archive)
+	if err != nil {
+		return err
+	}
+	jobs, err := d.listCopyJobs(bucket, prefix)
+	if err == nil {
+		err = d.copyObjects(bucket, archive, prefix, jobs, sse)
+	}
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "remove").Errorf("could not archive bucket '%s': %s", bucket, err)
+		return fmt.Errorf("could not archive bucket '%s': %s", bucket, err)
+	}
+	// expire archived data
+	days := d.volumeOption(vol, "archive_expire_days")
+	if len(days) > 0 && days != "0" {
+		err = d.setArchiveLifecycle(archive, name, archivePrefix+name+"/", days)
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "remove").Errorf("could not set archive lifecycle: %s", err)
+			return fmt.Errorf("could not set archive lifecycle: %s", err)
+		}
+	}
+	return nil
+}
+
+// lifecycleRule is a rule of a bucket lifecycle configuration, kept as is
+type lifecycleRule struct {
+	ID    string `xml:"ID"`
+	Inner string `xml:",innerxml"`
+}
+
+// setArchiveLifecycle expires the archives of a volume below the given prefix
+// after the given number of days. The rule of the volume is added to the
+// lifecycle configuration of the archive bucket or replaces its previous
+// version, other rules are kept.
+func (d *S3fsDriver) setArchiveLifecycle(bucket string, name string, prefix string, days string) error {
+	err := checkExpireDays(days)
+	if err != nil {
+		return err
+	}
+	current, err := d.s3client.GetBucketLifecycle(bucket)
+	if err != nil {
+		return fmt.Errorf("could not get lifecycle: %s", err)
+	}
+	lifecycle, err := mergeLifecycleRule(current, archiveRuleID+name, prefix, days)
+	if err != nil {
+		return err
+	}
+	return d.s3client.SetBucketLifecycle(bucket, lifecycle)
+}
+
+// mergeLifecycleRule adds an expiration rule to a lifecycle configuration or
+// replaces the rule with the same id
+func mergeLifecycleRule(current string, id string, prefix string, days string) (string, error) {
+	config := struct {
+		Rules []lifecycleRule `xml:"Rule"`
+	}{}
+	if len(strings.TrimSpace(current)) > 0 {
+		err := xml.Unmarshal([]byte(current), &config)
+		if err != nil {
+			return "", fmt.Errorf("could not parse lifecycle: %s", err)
+		}
+	}
+	var escaped bytes.Buffer
+	err := xml.EscapeText(&escaped, []byte(prefix))
+	if err != nil {
+		return "", err
+	}
+	rule := lifecycleRule{ID: id, Inner: fmt.Sprintf(archiveRule, id, escaped.String(), days)}
+	replaced := false
+	for i, r := range config.Rules {
+		if r.ID == id {
+			config.Rules[i] = rule
+			replaced = true
+		}
+	}
+	if !replaced {
+		config.Rules = append(config.Rules, rule)
+	}
+	lifecycle := "<LifecycleConfiguration>"
+	for _, r := range config.Rules {
+		lifecycle += "<Rule>" + r.Inner + "</Rule>"
+	}
+	lifecycle += "</LifecycleConfiguration>"
+	return lifecycle, nil
+}
+
+// checkArchivePrefix validates the archive prefix: in the configuration
+// bucket it must be a directory apart from the data of the plugin
+func checkArchivePrefix(prefix string, configBucket bool) error {
+	if !configBucket {
+		return nil
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("archive prefix %q must end with / to archive into the configuration bucket", prefix)
+	}
+	for _, reserved := range []string{mountsPrefix, snapshotsPrefix, keysPrefix, clonesPrefix} {
+		if strings.HasPrefix(prefix, reserved) || strings.HasPrefix(reserved, prefix) {
+			return fmt.Errorf("archive prefix %q overlaps the %s data of the plugin", prefix, reserved)
+		}
+	}
+	return nil
+}
+
+// archiveTarget gets the bucket and the prefix where a volume is archived
+func (d *S3fsDriver) archiveTarget(vol *VolConfig) (string, string, error) {
+	archive := d.volumeOption(vol, "archive_bucket")
+	if len(archive) == 0 {
+		archive = d.conf["configbucket"]
+	}
+	prefix := d.volumeOption(vol, "archive_prefix")
+	return archive, prefix, checkArchivePrefix(prefix, archive == d.conf["configbucket"])
+}
+
+// checkExpireDays validates the number of days archived data is kept
+func checkExpireDays(days string) error {
+	if len(days) == 0 {
+		return nil
+	}
+	n, err := strconv.Atoi(days)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid archive expiration days: %s", days)
+	}
+	return nil
+}
+
+// checkInUse verifies that a volume is not mounted on this or other hosts
+func (d *S3fsDriver) checkInUse(name string) error {
+	d.mountsLock.Lock()
+	count := d.mounts[name]
+	d.mountsLock.Unlock()
+	if count > 0 {
+		return fmt.Errorf("volume %s is used by %d containers", name, count)
+	}
+	if isMounted(fmt.Sprintf("%s/%s", d.conf["rootmount"], name)) {
+		return fmt.Errorf("volume %s is mounted on this host", name)
+	}
+	hosts, err := d.mountHosts(name)
+	if err != nil {
+		return err
+	}
+	if len(hosts) > 0 {
+		return fmt.Errorf("volume %s is mounted on %s", name, strings.Join(hosts, ", "))
+	}
+	return nil
+}
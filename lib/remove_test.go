@@ -0,0 +1,62 @@
+package dockerVolumeS3
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeLifecycleRule(t *testing.T) {
+	other := `<Rule><ID>keep-logs</ID><Filter><Prefix>logs/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>7</Days></Expiration></Rule>`
+	tests := []struct {
+		name    string
+		current string
+		rules   []string
+	}{
+		{"empty", "", []string{"<ID>docker-volume-s3-archive-data</ID><Filter><Prefix>archive/data/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>30</Days></Expiration>"}},
+		{"keeps other rules", "<LifecycleConfiguration>" + other + "</LifecycleConfiguration>", []string{"<ID>keep-logs</ID>", "<ID>docker-volume-s3-archive-data</ID>"}},
+		{"replaces the rule of the volume", "<LifecycleConfiguration>" + other + "<Rule><ID>docker-volume-s3-archive-data</ID><Filter><Prefix>archive/data/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>5</Days></Expiration></Rule></LifecycleConfiguration>", []string{"<ID>keep-logs</ID>", "<Days>30</Days>"}},
+	}
+	for _, tt := range tests {
+		got, err := mergeLifecycleRule(tt.current, archiveRuleID+"data", "archive/data/", "30")
+		if err != nil {
+			t.Errorf("%s: mergeLifecycleRule failed: %s", tt.name, err)
+			continue
+		}
+		for _, rule := range tt.rules {
+			if !strings.Contains(got, rule) {
+				t.Errorf("%s: lifecycle %s does not contain %s", tt.name, got, rule)
+			}
+		}
+		if n := strings.Count(got, "<ID>"+archiveRuleID+"data</ID>"); n != 1 {
+			t.Errorf("%s: lifecycle %s has %d rules for the volume", tt.name, got, n)
+		}
+		if strings.Contains(got, "<Days>5</Days>") {
+			t.Errorf("%s: lifecycle %s kept the previous rule of the volume", tt.name, got)
+		}
+	}
+	if _, err := mergeLifecycleRule("<LifecycleConfiguration>", "id", "p/", "1"); err == nil {
+		t.Errorf("mergeLifecycleRule accepted an invalid lifecycle")
+	}
+}
+
+func TestCheckArchivePrefix(t *testing.T) {
+	tests := []struct {
+		prefix       string
+		configBucket bool
+		ok           bool
+	}{
+		{"archive/", true, true},
+		{"old/archive/", true, true},
+		{"", true, false},
+		{"archive", true, false},
+		{"keys/", true, false},
+		{"mounts/old/", true, false},
+		{"", false, true},
+		{"keys/", false, true},
+	}
+	for _, tt := range tests {
+		if err := checkArchivePrefix(tt.prefix, tt.configBucket); (err == nil) != tt.ok {
+			t.Errorf("checkArchivePrefix(%q, %v) = %v, want ok %v", tt.prefix, tt.configBucket, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package dockerVolumeS3
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/encrypt"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	userMetaPrefix = "X-Amz-Meta-"
+)
+
+// parseRestoreTime parses the point in time a volume is restored to, now if empty
+func parseRestoreTime(at string) (time.Time, error) {
+	if len(at) == 0 {
+		return time.Now(), nil
+	}
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return t, fmt.Errorf("invalid restore time %s: %s", at, err)
+	}
+	return t, nil
+}
+
+// restoreVolume restores a volume as of a point in time into a bucket
+func (d *S3fsDriver) restoreVolume(name string, bucket string, at string, sse encrypt.ServerSide) error {
+	src, err := d.volumeBucket(name)
+	if err != nil {
+		return err
+	}
+	t, err := parseRestoreTime(at)
+	if err != nil {
+		return err
+	}
+	return d.restoreBucket(src, bucket, t, sse)
+}
+
+// restoreBucket copies the latest version before a point in time of every
+// object of a bucket into another bucket, encrypted with dstSSE
+func (d *S3fsDriver) restoreBucket(src string, dst string, at time.Time, dstSSE encrypt.ServerSide) error {
+	log.WithField("command", "driver").WithField("method", "restore").Infof("restoring bucket %s as of %s into %s", src, at.UTC().Format(time.RFC3339), dst)
+	versions, err := d.listObjectVersions(src, "")
+	if err != nil {
+		return err
+	}
+	srcSSE, err := d.bucketServerSide(src)
+	if err != nil {
+		return err
+	}
+	// find the latest version of each object before the restore time
+	latest := make(map[string]objectVersion)
+	for _, v := range versions {
+		if v.LastModified.After(at) {
+			continue
+		}
+		if cur, ok := latest[v.Key]; !ok || v.LastModified.After(cur.LastModified) {
+			latest[v.Key] = v
+		}
+	}
+	count := 0
+	for _, v := range latest {
+		if v.DeleteMarker {
+			continue
+		}
+		err = d.copyVersion(src, srcSSE, v, dst, dstSSE)
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "restore").Errorf("could not restore object %s: %s", v.Key, err)
+			return fmt.Errorf("could not restore object %s: %s", v.Key, err)
+		}
+		count++
+	}
+	log.WithField("command", "driver").WithField("method", "restore").Infof("restored %d objects from bucket %s into %s", count, src, dst)
+	return nil
+}
+
+// copyVersion copies a version of an object, keeping its metadata
+func (d *S3fsDriver) copyVersion(src string, srcSSE encrypt.ServerSide, v objectVersion, dst string, dstSSE encrypt.ServerSide) error {
+	params := url.Values{}
+	params.Set("versionId", v.VersionID)
+	u, err := d.s3client.Presign(http.MethodGet, src, v.Key, presignExpiry, params)
+	if err != nil {
+		return fmt.Errorf("could not presign object: %s", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("could not get object: %s", err)
+	}
+	if key := customerKey(srcSSE); key != nil {
+		key.Marshal(req.Header)
+	}
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not get object: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not get object: %s", resp.Status)
+	}
+	opts := minio.PutObjectOptions{
+		ContentType:          resp.Header.Get("Content-Type"),
+		UserMetadata:         make(map[string]string),
+		ServerSideEncryption: dstSSE,
+	}
+	for k := range resp.Header {
+		if strings.HasPrefix(k, userMetaPrefix) {
+			opts.UserMetadata[strings.ToLower(strings.TrimPrefix(k, userMetaPrefix))] = resp.Header.Get(k)
+		}
+	}
+	_, err = d.s3client.PutObject(dst, v.Key, resp.Body, resp.ContentLength, opts)
+	return err
+}
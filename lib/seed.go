@@ -0,0 +1,221 @@
+package dockerVolumeS3
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/encrypt"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	modeDir     = 0040000
+	modeRegular = 0100000
+	modeSymlink = 0120000
+
+	directoryContentType = "application/x-directory"
+)
+
+// dataPrefix gets the prefix of the data directory of a volume
+func (d *S3fsDriver) dataPrefix() string {
+	dir := strings.Trim(d.conf["mountdir"], "/")
+	if len(dir) == 0 {
+		return ""
+	}
+	return dir + "/"
+}
+
+// s3fsMetadata builds the object metadata s3fs uses for file attributes
+func s3fsMetadata(hdr *tar.Header, fileType int64) map[string]string {
+	return map[string]string{
+		"mode":  strconv.FormatInt(fileType|(hdr.Mode&07777), 10),
+		"uid":   strconv.Itoa(hdr.Uid),
+		"gid":   strconv.Itoa(hdr.Gid),
+		"mtime": strconv.FormatInt(hdr.ModTime.Unix(), 10),
+	}
+}
+
+// seedPath checks that a local seed archive is below one of the directories
+// allowed by the seedpaths setting
+func (d *S3fsDriver) seedPath(seed string) (string, error) {
+	if !filepath.IsAbs(seed) {
+		return "", fmt.Errorf("seed path %s is not absolute", seed)
+	}
+	file, err := filepath.EvalSymlinks(filepath.Clean(seed))
+	if err != nil {
+		return "", fmt.Errorf("could not open %s: %s", seed, err)
+	}
+	for _, dir := range strings.Split(d.conf["seedpaths"], ",") {
+		dir = strings.TrimSpace(dir)
+		if len(dir) == 0 {
+			continue
+		}
+		dir, err = filepath.EvalSymlinks(filepath.Clean(dir))
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("seed %s is not below the allowed seed paths", seed)
+}
+
+// seedURL checks that a http seed url is below one of the url prefixes
+// allowed by the seedurls setting
+func (d *S3fsDriver) seedURL(seed string) error {
+	for _, prefix := range strings.Split(d.conf["seedurls"], ",") {
+		prefix = strings.TrimSpace(prefix)
+		if len(prefix) == 0 || !strings.HasPrefix(seed, prefix) {
+			continue
+		}
+		// the prefix must end at a path boundary, https://example.com does not
+		// allow https://example.com.other.org
+		rest := seed[len(prefix):]
+		if strings.HasSuffix(prefix, "/") || len(rest) == 0 || rest[0] == '/' || rest[0] == '?' {
+			return nil
+		}
+	}
+	return fmt.Errorf("seed %s is not below the allowed seed urls", seed)
+}
+
+// checkSeedRedirect only follows redirects to allowed seed urls
+func (d *S3fsDriver) checkSeedRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	return d.seedURL(req.URL.String())
+}
+
+// openSeed opens a seed archive from a s3 url of a volume bucket, an allowed
+// http url or an allowed local path
+func (d *S3fsDriver) openSeed(seed string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasPrefix(seed, "s3://"):
+		infos := strings.SplitN(strings.TrimPrefix(seed, "s3://"), "/", 2)
+		if len(infos) != 2 || len(infos[1]) == 0 {
+			return nil, fmt.Errorf("invalid s3 url %s: expected s3://bucket/key", seed)
+		}
+		if !d.exposed(infos[0]) {
+			return nil, fmt.Errorf("bucket '%s' is not a volume bucket", infos[0])
+		}
+		sse, err := d.bucketServerSide(infos[0])
+		if err != nil {
+			return nil, err
+		}
+		obj, err := d.s3client.GetObject(infos[0], infos[1], getOptions(sse))
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s: %s", seed, err)
+		}
+		return obj, nil
+	case strings.HasPrefix(seed, "http://") || strings.HasPrefix(seed, "https://"):
+		err := d.seedURL(seed)
+		if err != nil {
+			return nil, err
+		}
+		// seeds are not fetched with the transport of the s3 endpoint
+		resp, err := d.seedClient.Get(seed)
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s: %s", seed, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("could not get %s: %s", seed, resp.Status)
+		}
+		return resp.Body, nil
+	default:
+		file, err := d.seedPath(seed)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not open %s: %s", seed, err)
+		}
+		return f, nil
+	}
+}
+
+// seedBucket fills a bucket from a tar or tar.gz archive, encrypting the
+// objects with sse
+func (d *S3fsDriver) seedBucket(seed string, bucket string, sse encrypt.ServerSide) error {
+	log.WithField("command", "driver").WithField("method", "seed").Infof("seeding bucket %s from %s", bucket, seed)
+	src, err := d.openSeed(seed)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	reader := bufio.NewReader(src)
+	var archive io.Reader = reader
+	// detect gzip compression
+	magic, err := reader.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("could not decompress %s: %s", seed, err)
+		}
+		defer gz.Close()
+		archive = gz
+	}
+	prefix := d.dataPrefix()
+	if len(prefix) > 0 {
+		err = d.putDirectory(bucket, prefix, &tar.Header{Mode: 0755}, sse)
+		if err != nil {
+			return err
+		}
+	}
+	count := 0
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read %s: %s", seed, err)
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if len(name) == 0 {
+			continue
+		}
+		key := prefix + name
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = d.putDirectory(bucket, key+"/", hdr, sse)
+		case tar.TypeReg, tar.TypeRegA:
+			_, err = d.s3client.PutObject(bucket, key, tr, hdr.Size, minio.PutObjectOptions{UserMetadata: s3fsMetadata(hdr, modeRegular), ServerSideEncryption: sse})
+		case tar.TypeSymlink:
+			target := strings.NewReader(hdr.Linkname)
+			_, err = d.s3client.PutObject(bucket, key, target, target.Size(), minio.PutObjectOptions{UserMetadata: s3fsMetadata(hdr, modeSymlink), ServerSideEncryption: sse})
+		case tar.TypeLink:
+			err = d.copyObject(bucket, prefix+strings.TrimPrefix(path.Clean("/"+hdr.Linkname), "/"), sse, -1, bucket, key, sse)
+		default:
+			log.WithField("command", "driver").WithField("method", "seed").Warnf("skipping unsupported entry %s of type %c", hdr.Name, hdr.Typeflag)
+			continue
+		}
+		if err != nil {
+			log.WithField("command", "driver").WithField("method", "seed").Errorf("could not put %s: %s", key, err)
+			return fmt.Errorf("could not put %s: %s", key, err)
+		}
+		count++
+	}
+	log.WithField("command", "driver").WithField("method", "seed").Infof("seeded bucket %s with %d entries", bucket, count)
+	return nil
+}
+
+// putDirectory puts a s3fs directory object
+func (d *S3fsDriver) putDirectory(bucket string, key string, hdr *tar.Header, sse encrypt.ServerSide) error {
+	_, err := d.s3client.PutObject(bucket, key, strings.NewReader(""), 0, minio.PutObjectOptions{ContentType: directoryContentType, UserMetadata: s3fsMetadata(hdr, modeDir), ServerSideEncryption: sse})
+	return err
+}
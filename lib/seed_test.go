@@ -0,0 +1,26 @@
+package dockerVolumeS3
+
+import "testing"
+
+func TestSeedURL(t *testing.T) {
+	tests := []struct {
+		urls string
+		seed string
+		ok   bool
+	}{
+		{"", "https://example.com/web.tar.gz", false},
+		{"https://example.com", "https://example.com/web.tar.gz", true},
+		{"https://example.com/", "https://example.com/web.tar.gz", true},
+		{"https://example.com", "https://example.com.other.org/web.tar.gz", false},
+		{"https://example.com/seeds", "https://example.com/seeds?v=1", true},
+		{"https://example.com/seeds", "https://example.com/seedsx/web.tar.gz", false},
+		{"https://example.com/seeds/", "http://example.com/seeds/web.tar.gz", false},
+		{"https://mirror.example.org/, https://example.com/seeds/", "https://example.com/seeds/web.tar.gz", true},
+	}
+	for _, tt := range tests {
+		d := &S3fsDriver{conf: map[string]string{"seedurls": tt.urls}}
+		if err := d.seedURL(tt.seed); (err == nil) != tt.ok {
+			t.Errorf("seedURL(%q) with %q = %v, want ok %v", tt.seed, tt.urls, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package dockerVolumeS3
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/encrypt"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	snapshotsPrefix  = "snapshots/"
+	snapshotData     = "data/"
+	snapshotManifest = "manifest.json"
+)
+
+// SnapshotManifest describes the objects of a snapshot
+type SnapshotManifest struct {
+	Volume  string           `json:"volume"`
+	Bucket  string           `json:"bucket"`
+	Created time.Time        `json:"created"`
+	Objects []SnapshotObject `json:"objects"`
+}
+
+// SnapshotObject is an object of a snapshot
+type SnapshotObject struct {
+	Key  string `json:"key"`
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+// snapshotPrefix gets the prefix of a snapshot in the configuration bucket
+func snapshotPrefix(name string, snapshot string) string {
+	return fmt.Sprintf("%s%s/%s/", snapshotsPrefix, name, snapshot)
+}
+
+// parseSnapshot splits a volume/snapshot reference
+func parseSnapshot(ref string) (string, string, error) {
+	infos := strings.SplitN(ref, "/", 2)
+	if len(infos) != 2 || len(infos[0]) == 0 || len(infos[1]) == 0 {
+		return "", "", fmt.Errorf("invalid snapshot %s: expected volume/snapshot", ref)
+	}
+	return infos[0], infos[1], nil
+}
+
+// Snapshot copies the objects of a volume to a snapshot in the configuration
+// bucket and returns the snapshot name
+func (d *S3fsDriver) Snapshot(name string, snapshot string) (string, error) {
+	bucket, err := d.volumeBucket(name)
+	if err != nil {
+		return "", err
+	}
+	if len(snapshot) == 0 {
+		snapshot = time.Now().UTC().Format("20060102T150405Z")
+	}
+	if strings.Contains(snapshot, "/") {
+		return "", fmt.Errorf("invalid snapshot name %s", snapshot)
+	}
+	sse, err := d.serverSide(nil)
+	if err != nil {
+		return "", err
+	}
+	prefix := snapshotPrefix(name, snapshot)
+	_, err = d.s3client.StatObject(d.conf["configbucket"], prefix+snapshotManifest, statOptions(sse))
+	if err == nil {
+		return "", fmt.Errorf("snapshot %s/%s already exists", name, snapshot)
+	}
+	log.WithField("command", "driver").WithField("method", "snapshot").Infof("taking snapshot %s of volume %s", snapshot, name)
+	manifest := SnapshotManifest{
+		Volume:  name,
+		Bucket:  bucket,
+		Created: time.Now().UTC(),
+	}
+	jobs, err := d.listCopyJobs(bucket, prefix+snapshotData)
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "snapshot").Errorf("could not list volume %s: %s", name, err)
+		return "", fmt.Errorf("could not list volume %s: %s", name, err)
+	}
+	err = d.copyObjects(bucket, d.conf["configbucket"], prefix+snapshotData, jobs, sse)
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "snapshot").Errorf("could not copy volume %s: %s", name, err)
+		return "", fmt.Errorf("could not copy volume %s: %s", name, err)
+	}
+	for _, job := range jobs {
+		manifest.Objects = append(manifest.Objects, SnapshotObject{Key: job.src, ETag: job.etag, Size: job.size})
+	}
+	// the manifest marks the snapshot as complete
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("could not encode snapshot manifest: %s", err)
+	}
+	_, err = d.s3client.PutObject(d.conf["configbucket"], prefix+snapshotManifest, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: "application/json", ServerSideEncryption: sse})
+	if err != nil {
+		log.WithField("command", "driver").WithField("method", "snapshot").Errorf("could not put snapshot manifest: %s", err)
+		return "", fmt.Errorf("could not put snapshot manifest: %s", err)
+	}
+	log.WithField("command", "driver").WithField("method", "snapshot").Infof("snapshot %s of volume %s contains %d objects", snapshot, name, len(manifest.Objects))
+	return snapshot, nil
+}
+
+// Snapshots lists the snapshots of a volume
+func (d *S3fsDriver) Snapshots(name string) ([]string, error) {
+	var snapshots []string
+	prefix := snapshotsPrefix + name + "/"
+	for object := range d.s3client.ListObjects(d.conf["configbucket"], prefix, true, nil) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("could not list snapshots: %s", object.Err)
+		}
+		if strings.HasSuffix(object.Key, "/"+snapshotManifest) {
+			snapshots = append(snapshots, strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), "/"+snapshotManifest))
+		}
+	}
+	return snapshots, nil
+}
+
+// getSnapshotManifest reads the manifest of a snapshot
+func (d *S3fsDriver) getSnapshotManifest(name string, snapshot string) (*SnapshotManifest, error) {
+	sse, err := d.serverSide(nil)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := d.s3client.GetObject(d.conf["configbucket"], snapshotPrefix(name, snapshot)+snapshotManifest, getOptions(sse))
+	if err != nil {
+		return nil, fmt.Errorf("could not get snapshot %s/%s: %s", name, snapshot, err)
+	}
+	defer obj.Close()
+	manifest := &SnapshotManifest{}
+	err = json.NewDecoder(obj).Decode(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot %s/%s: %s", name, snapshot, err)
+	}
+	return manifest, nil
+}
+
+// restoreSnapshot copies the objects of a snapshot into a bucket
+func (d *S3fsDriver) restoreSnapshot(ref string, bucket string, sse encrypt.ServerSide) error {
+	name, snapshot, err := parseSnapshot(ref)
+	if err != nil {
+		return err
+	}
+	manifest, err := d.getSnapshotManifest(name, snapshot)
+	if err != nil {
+		return err
+	}
+	log.WithField("command", "driver").WithField("method", "restore").Infof("restoring snapshot %s into bucket %s", ref, bucket)
+	prefix := snapshotPrefix(name, snapshot) + snapshotData
+	var jobs []copyJob
+	for _, object := range manifest.Objects {
+		jobs = append(jobs, copyJob{src: prefix + object.Key, dst: object.Key, etag: object.ETag, size: object.Size})
+	}
+	return d.copyObjects(d.conf["configbucket"], bucket, "", jobs, sse)
+}
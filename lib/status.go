@@ -0,0 +1,75 @@
+package dockerVolumeS3
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/minio/minio-go/v6"
+)
+
+const (
+	backendS3fs = "s3fs"
+
+	healthHealthy    = "healthy"
+	healthUnhealthy  = "unhealthy"
+	healthNotMounted = "not mounted"
+	healthNoBucket   = "bucket missing"
+)
+
+// volumeInfo builds the docker description of a volume from its registry
+// entry and its bucket
+func (d *S3fsDriver) volumeInfo(name string, vol *VolConfig, bucket minio.BucketInfo) *volume.Volume {
+	created := bucket.CreationDate
+	if vol != nil && !vol.Created.IsZero() {
+		created = vol.Created
+	}
+	creation := ""
+	if !created.IsZero() {
+		creation = created.UTC().Format(time.RFC3339)
+	}
+	return &volume.Volume{
+		Name:       name,
+		Mountpoint: fmt.Sprintf("%s/%s", d.conf["rootmount"], name),
+		CreatedAt:  creation,
+		Status:     d.volumeStatus(name, vol, bucket),
+	}
+}
+
+// volumeStatus describes the configuration and the state of a volume
+func (d *S3fsDriver) volumeStatus(name string, vol *VolConfig, bucket minio.BucketInfo) map[string]interface{} {
+	status := make(map[string]interface{})
+	status["backend"] = backendS3fs
+	status["bucket"] = bucket.Name
+	if vol != nil {
+		status["bucket"] = vol.Bucket
+		status["options"] = redactOptions(vol.Options)
+		if clientEncrypted(vol) {
+			status["backend"] = backendRclone
+		}
+	}
+	status["prefix"] = d.dataPrefix()
+	path := fmt.Sprintf("%s/%s", d.conf["rootmount"], name)
+	d.mountsLock.Lock()
+	count := d.mounts[name]
+	d.mountsLock.Unlock()
+	status["mounted"] = count > 0
+	status["containers"] = count
+	switch {
+	case len(bucket.Name) == 0:
+		status["health"] = healthNoBucket
+	case count == 0:
+		status["health"] = healthNotMounted
+	case d.cachedHealth(path):
+		status["health"] = healthHealthy
+	default:
+		status["health"] = healthUnhealthy
+	}
+	if usage, ok := d.Usage(name); ok {
+		status["usage"] = usage
+	}
+	if d.isOverQuota(name) {
+		status["quota"] = "exceeded"
+	}
+	return status
+}
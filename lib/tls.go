@@ -0,0 +1,73 @@
+package dockerVolumeS3
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsConfig builds the tls configuration of the endpoint from the CA bundle,
+// the client certificate and the insecure mode
+func (d *S3fsDriver) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: d.conf["insecure"] == "true"}
+	if bundle := d.conf["cabundle"]; len(bundle) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %s", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA bundle %s", bundle)
+		}
+		config.RootCAs = pool
+	}
+	cert, key := d.conf["clientcert"], d.conf["clientkey"]
+	if len(cert) > 0 || len(key) > 0 {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+// tlsMountOptions adds the tls settings to s3fs options
+func (d *S3fsDriver) tlsMountOptions(opts map[string]string) {
+	if d.conf["insecure"] == "true" {
+		opts["ssl_verify_hostname"] = "0"
+		opts["no_check_certificate"] = "true"
+	}
+	if d.conf["tlsdebug"] == "true" {
+		opts["curldbg"] = "true"
+	}
+}
+
+// tlsRcloneArgs gets the rclone flags of the tls settings
+func (d *S3fsDriver) tlsRcloneArgs() []string {
+	var args []string
+	if d.conf["insecure"] == "true" {
+		args = append(args, "--no-check-certificate")
+	}
+	if len(d.conf["cabundle"]) > 0 {
+		args = append(args, "--ca-cert", d.conf["cabundle"])
+	}
+	if len(d.conf["clientcert"]) > 0 {
+		args = append(args, "--client-cert", d.conf["clientcert"], "--client-key", d.conf["clientkey"])
+	}
+	return args
+}
+
+// mountEnv gets the environment of the mount commands
+func (d *S3fsDriver) mountEnv() []string {
+	env := d.proxyEnv()
+	// s3fs passes the bundle to curl
+	if len(d.conf["cabundle"]) > 0 {
+		env = append(env, "CURL_CA_BUNDLE="+d.conf["cabundle"])
+	}
+	return env
+}
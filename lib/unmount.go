@@ -0,0 +1,256 @@
+package dockerVolumeS3
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	unmountRetry = "retry"
+	unmountLazy  = "lazy"
+	unmountForce = "force"
+
+	healthTimeout = 5 * time.Second
+)
+
+// parseUnmountStrategies parses the comma separated list of unmount strategies
+// tried, in order, when a plain umount fails
+func parseUnmountStrategies(strategies string) ([]string, error) {
+	var res []string
+	for _, s := range strings.Split(strategies, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		switch s {
+		case "":
+			continue
+		case unmountRetry, unmountLazy, unmountForce:
+			res = append(res, s)
+		default:
+			return nil, fmt.Errorf("unknown unmount strategy: %s", s)
+		}
+	}
+	return res, nil
+}
+
+// unmountSettings parses the retries and initial backoff of the retry
+// strategy
+func unmountSettings(conf map[string]string) (int, time.Duration, error) {
+	retries, err := strconv.Atoi(conf["unmountretries"])
+	if err != nil || retries < 0 {
+		return 0, 0, fmt.Errorf("invalid unmount retries: %s", conf["unmountretries"])
+	}
+	backoff, err := time.ParseDuration(conf["unmountbackoff"])
+	if err != nil || backoff < 0 {
+		return 0, 0, fmt.Errorf("invalid unmount backoff: %s", conf["unmountbackoff"])
+	}
+	return retries, backoff, nil
+}
+
+// unmount unmounts a path, falling back to the configured strategies if the
+// mount is busy
+func (d *S3fsDriver) unmount(path string) error {
+	if !isMounted(path) {
+		log.WithField("command", "driver").WithField("method", "unmount").Warnf("%s is not mounted", path)
+		return nil
+	}
+	strategies, err := parseUnmountStrategies(d.conf["unmountstrategy"])
+	if err != nil {
+		return err
+	}
+	return d.unmountWith(path, strategies)
+}
+
+// unmountWith unmounts a path, falling back to the given strategies
+func (d *S3fsDriver) unmountWith(path string, strategies []string) error {
+	retries, backoff, err := unmountSettings(d.conf)
+	if err != nil {
+		return err
+	}
+	err = runCommand("umount", path)
+	for _, strategy := range strategies {
+		if err == nil {
+			break
+		}
+		log.WithField("command", "driver").WithField("method", "unmount").Warnf("could not unmount %s: %s, trying %s unmount", path, err, strategy)
+		switch strategy {
+		case unmountRetry:
+			wait := backoff
+			for i := 0; i < retries && err != nil; i++ {
+				time.Sleep(wait)
+				wait *= 2
+				err = runCommand("umount", path)
+			}
+		case unmountLazy:
+			holders := mountHolders(path)
+			err = runCommand("fusermount", "-u", "-z", path)
+			if err == nil && len(holders) > 0 {
+				log.WithField("command", "driver").WithField("method", "unmount").Warnf("lazy unmounted %s still held by: %s", path, strings.Join(holders, ", "))
+			}
+		case unmountForce:
+			err = runCommand("umount", "-f", path)
+		}
+	}
+	if err != nil {
+		holders := mountHolders(path)
+		if len(holders) > 0 {
+			return fmt.Errorf("%s: mount is held by %s", err, strings.Join(holders, ", "))
+		}
+		return err
+	}
+	return nil
+}
+
+// isMounted checks if a path is a mount point
+func isMounted(path string) bool {
+	data, err := ioutil.ReadFile("/proc/self/mounts")
+	if err != nil {
+		log.WithField("command", "driver").Debugf("could not read mounts: %s", err)
+		return true
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[1] == path {
+			return true
+		}
+	}
+	return false
+}
+
+// isReadOnly checks if a mount point is mounted read only
+func isReadOnly(path string) bool {
+	data, err := ioutil.ReadFile("/proc/self/mounts")
+	if err != nil {
+		log.WithField("command", "driver").Debugf("could not read mounts: %s", err)
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 3 && fields[1] == path {
+			for _, option := range strings.Split(fields[3], ",") {
+				if option == "ro" {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// healthState is the last health check of a mount
+type healthState struct {
+	healthy bool
+	checked time.Time
+	probe   *healthProbe // running stat, nil when none
+}
+
+// healthProbe is a stat of a mount point that may never return
+type healthProbe struct {
+	started time.Time
+	done    chan struct{}
+	err     error
+}
+
+// isHealthy checks that a path is mounted and that its fuse process answers
+// within healthTimeout. A stat of a hung mount may never return: it is left
+// running and no other stat of the path is started until it returns.
+func (d *S3fsDriver) isHealthy(path string) bool {
+	if !isMounted(path) {
+		d.setHealth(path, false)
+		return false
+	}
+	d.healthLock.Lock()
+	state := d.health[path]
+	probe := state.probe
+	if probe == nil {
+		probe = &healthProbe{started: time.Now(), done: make(chan struct{})}
+		state.probe = probe
+		d.health[path] = state
+		go func() {
+			_, probe.err = os.Stat(path)
+			close(probe.done)
+			d.healthLock.Lock()
+			defer d.healthLock.Unlock()
+			d.health[path] = healthState{healthy: probe.err == nil, checked: time.Now()}
+		}()
+	}
+	d.healthLock.Unlock()
+	wait := healthTimeout - time.Since(probe.started)
+	if wait < 0 {
+		wait = 0
+	}
+	select {
+	case <-probe.done:
+		return probe.err == nil
+	case <-time.After(wait):
+		log.WithField("command", "driver").Warnf("mount %s did not answer within %s", path, healthTimeout)
+		d.setHealth(path, false)
+		return false
+	}
+}
+
+// cachedHealth gets the health of a mount from the last check. Stale results
+// are refreshed in the background, only the first check of a path waits.
+func (d *S3fsDriver) cachedHealth(path string) bool {
+	d.healthLock.Lock()
+	state, ok := d.health[path]
+	d.healthLock.Unlock()
+	if !ok {
+		return d.isHealthy(path)
+	}
+	if !d.fresh(state.checked) && state.probe == nil {
+		go d.isHealthy(path)
+	}
+	return state.healthy
+}
+
+// setHealth records the health of a mount, keeping a running stat
+func (d *S3fsDriver) setHealth(path string, healthy bool) {
+	d.healthLock.Lock()
+	defer d.healthLock.Unlock()
+	state := d.health[path]
+	state.healthy = healthy
+	state.checked = time.Now()
+	d.health[path] = state
+}
+
+// forgetHealth drops the health of an unmounted path
+func (d *S3fsDriver) forgetHealth(path string) {
+	d.healthLock.Lock()
+	defer d.healthLock.Unlock()
+	if d.health[path].probe == nil {
+		delete(d.health, path)
+	}
+}
+
+// mountHolders lists the processes using a path below the mount point
+func mountHolders(path string) []string {
+	var holders []string
+	pids, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return holders
+	}
+	for _, p := range pids {
+		links := []string{filepath.Join(p, "cwd"), filepath.Join(p, "root"), filepath.Join(p, "exe")}
+		fds, _ := filepath.Glob(filepath.Join(p, "fd", "*"))
+		links = append(links, fds...)
+		for _, l := range links {
+			target, err := os.Readlink(l)
+			if err != nil {
+				continue
+			}
+			if target == path || strings.HasPrefix(target, path+"/") {
+				comm, _ := ioutil.ReadFile(filepath.Join(p, "comm"))
+				holders = append(holders, fmt.Sprintf("%s(%s)", filepath.Base(p), strings.TrimSpace(string(comm))))
+				break
+			}
+		}
+	}
+	return holders
+}
@@ -0,0 +1,143 @@
+package dockerVolumeS3
+
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	usageList  = "list"
+	usageMinio = "minio"
+)
+
+// VolumeUsage is the storage used by a volume
+type VolumeUsage struct {
+	Objects      int64     `json:"objects"`
+	Bytes        int64     `json:"bytes"`
+	LastModified time.Time `json:"lastModified,omitempty"`
+	Scanned      time.Time `json:"scanned"`
+	Source       string    `json:"source"`
+}
+
+// minioDataUsage is the data usage reported by the MinIO admin API
+type minioDataUsage struct {
+	LastUpdate   time.Time `json:"lastUpdate"`
+	BucketsUsage map[string]struct {
+		Size         int64 `json:"size"`
+		ObjectsCount int64 `json:"objectsCount"`
+	} `json:"bucketsUsageInfo"`
+}
+
+// Usage gets the cached usage of a volume, a scan is started if there is none
+func (d *S3fsDriver) Usage(name string) (VolumeUsage, bool) {
+	d.usageLock.Lock()
+	defer d.usageLock.Unlock()
+	usage, ok := d.usage[name]
+	if !ok && !d.scanning[name] {
+		d.scanning[name] = true
+		go func() {
+			d.scanVolume(name)
+			d.usageLock.Lock()
+			delete(d.scanning, name)
+			d.usageLock.Unlock()
+		}()
+	}
+	return usage, ok
+}
+
+// setUsage caches the usage of a volume
+func (d *S3fsDriver) setUsage(name string, usage VolumeUsage) {
+	d.usageLock.Lock()
+	defer d.usageLock.Unlock()
+	d.usage[name] = usage
+}
+
+// scanVolume computes the usage of a volume by listing its bucket
+func (d *S3fsDriver) scanVolume(name string) {
+	bucket, err := d.volumeBucket(name)
+	if err != nil {
+		log.WithField("command", "usage").Warnf("could not get volume %s: %s", name, err)
+		return
+	}
+	usage := VolumeUsage{Source: usageList}
+	for object := range d.s3client.ListObjects(bucket, "", true, nil) {
+		if object.Err != nil {
+			log.WithField("command", "usage").Warnf("could not list bucket %s: %s", bucket, object.Err)
+			return
+		}
+		usage.Objects++
+		usage.Bytes += object.Size
+		if object.LastModified.After(usage.LastModified) {
+			usage.LastModified = object.LastModified
+		}
+	}
+	usage.Scanned = time.Now()
+	d.setUsage(name, usage)
+	d.enforceQuota(name, usage)
+	log.WithField("command", "usage").Debugf("volume %s uses %d bytes in %d objects", name, usage.Bytes, usage.Objects)
+}
+
+// scanMinio gets the usage of the volumes from the MinIO admin API
+func (d *S3fsDriver) scanMinio(buckets map[string]string) error {
+	data, err := d.minioAdmin("GET", "/datausageinfo", url.Values{}, nil)
+	if err != nil {
+		return err
+	}
+	info := minioDataUsage{}
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return err
+	}
+	for name, bucket := range buckets {
+		b, ok := info.BucketsUsage[bucket]
+		if !ok {
+			continue
+		}
+		usage := VolumeUsage{Objects: b.ObjectsCount, Bytes: b.Size, Scanned: info.LastUpdate, Source: usageMinio}
+		d.setUsage(name, usage)
+		d.enforceQuota(name, usage)
+	}
+	return nil
+}
+
+// scanUsage computes the usage of every volume
+func (d *S3fsDriver) scanUsage() {
+	vols, err := d.List()
+	if err != nil {
+		log.WithField("command", "usage").Warnf("could not list volumes: %s", err)
+		return
+	}
+	buckets := make(map[string]string)
+	for _, v := range vols.Volumes {
+		bucket, err := d.volumeBucket(v.Name)
+		if err == nil {
+			buckets[v.Name] = bucket
+		}
+	}
+	if d.conf["usagebackend"] == usageMinio {
+		err = d.scanMinio(buckets)
+		if err == nil {
+			return
+		}
+		log.WithField("command", "usage").Warnf("could not get minio data usage, listing buckets: %s", err)
+	}
+	for name := range buckets {
+		d.scanVolume(name)
+	}
+}
+
+// ScanUsage periodically computes the usage of the volumes
+func (d *S3fsDriver) ScanUsage() {
+	interval, err := time.ParseDuration(d.conf["usageinterval"])
+	if err != nil || interval <= 0 {
+		log.WithField("command", "usage").Warnf("usage scanner disabled: invalid interval %s", d.conf["usageinterval"])
+		return
+	}
+	for {
+		d.scanUsage()
+		time.Sleep(interval)
+	}
+}
@@ -2,9 +2,13 @@ package dockerVolumeS3
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
 	"sort"
 	"strings"
 
+	"github.com/minio/minio-go/v6"
+	"github.com/minio/minio-go/v6/pkg/encrypt"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -55,8 +59,63 @@ func optionsToString(options map[string]string) string {
 	return strings.Join(strOption, ",")
 }
 
+// checkVolumeOptions validates the options of a volume
+func checkVolumeOptions(options map[string]string) error {
+	// the registry separates options with commas and fields with semicolons
+	for key, value := range options {
+		if len(key) == 0 || strings.ContainsAny(key, ",;=\r\n") {
+			return fmt.Errorf("invalid option name %q", key)
+		}
+		if strings.ContainsAny(value, ",;\r\n") {
+			return fmt.Errorf("invalid value of option %s: commas, semicolons and line breaks are not allowed", key)
+		}
+	}
+	err := checkRemovePolicy(options["remove_policy"])
+	if err != nil {
+		return err
+	}
+	err = checkExpireDays(options["archive_expire_days"])
+	if err != nil {
+		return err
+	}
+	_, err = parseRestoreTime(options["restore_at"])
+	if err != nil {
+		return err
+	}
+	if len(options["from_snapshot"]) > 0 {
+		_, _, err = parseSnapshot(options["from_snapshot"])
+		if err != nil {
+			return err
+		}
+	}
+	_, err = populateSource(options)
+	if err != nil {
+		return err
+	}
+	err = checkQuotaOptions(options)
+	if err != nil {
+		return err
+	}
+	return checkBucketOptions(options)
+}
+
+// copyObject copies an object server side, decrypting it with srcSSE and
+// encrypting the copy with dstSSE. Objects larger than a single copy request
+// allows, or of unknown (negative) size, are copied in parts.
+func (d *S3fsDriver) copyObject(srcBucket string, srcKey string, srcSSE encrypt.ServerSide, size int64, dstBucket string, dstKey string, dstSSE encrypt.ServerSide) error {
+	dst, err := minio.NewDestinationInfo(dstBucket, dstKey, dstSSE, nil)
+	if err != nil {
+		return err
+	}
+	src := minio.NewSourceInfo(srcBucket, srcKey, customerKey(srcSSE))
+	if size < 0 || size > maxCopySize {
+		return d.s3client.ComposeObject(dst, []minio.SourceInfo{src})
+	}
+	return d.s3client.CopyObject(dst, src)
+}
+
 func (d *S3fsDriver) createBucket(bucket string) error {
-	ok, err := d.s3client.BucketExists(bucket)
+	ok, err := d.bucketExists(bucket)
 	if err != nil {
 		log.WithField("command", "driver").Errorf("could not check existance of bucket %s: %s", bucket, err)
 		return fmt.Errorf("could not check existance of bucket %s: %s", bucket, err)
@@ -64,6 +123,7 @@ func (d *S3fsDriver) createBucket(bucket string) error {
 	if !ok {
 		// create bucket
 		err = d.s3client.MakeBucket(bucket, d.conf["region"])
+		d.invalidateCache()
 		if err != nil {
 			log.WithField("command", "driver").Errorf("could not create bucket %s: %s", bucket, err)
 			return fmt.Errorf("could not create bucket %s: %s", bucket, err)
@@ -71,3 +131,25 @@ func (d *S3fsDriver) createBucket(bucket string) error {
 	}
 	return nil
 }
+
+// runCommand runs a command and reports its output on failure
+func runCommand(name string, args ...string) error {
+	return runCommandEnv(nil, name, args...)
+}
+
+// runCommandEnv runs a command with additional environment variables
+func runCommandEnv(env []string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		message := strings.TrimSpace(string(out))
+		if len(message) > 0 {
+			return fmt.Errorf("error executing %s: '%s'", name, strings.ReplaceAll(message, "\n", "\\n"))
+		}
+		return fmt.Errorf("error executing %s: %s", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package dockerVolumeS3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+)
+
+const (
+	presignExpiry = 15 * time.Minute
+
+	// maxDeleteObjects is the number of objects a multi-object delete accepts
+	maxDeleteObjects = 1000
+)
+
+// objectVersion describes a version or a delete marker of an object
+type objectVersion struct {
+	Key          string
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool
+	LastModified time.Time
+	ETag         string
+	Size         int64
+	DeleteMarker bool `xml:"-"`
+}
+
+// listVersionsResult is the response of a ListObjectVersions request
+type listVersionsResult struct {
+	XMLName             xml.Name `xml:"ListVersionsResult"`
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string          `xml:"NextVersionIdMarker"`
+	Versions            []objectVersion `xml:"Version"`
+	DeleteMarkers       []objectVersion `xml:"DeleteMarker"`
+}
+
+// isVersioned checks if versioning is enabled or suspended on a bucket
+func (d *S3fsDriver) isVersioned(bucket string) (bool, error) {
+	conf, err := d.s3client.GetBucketVersioning(bucket)
+	if minio.ToErrorResponse(err).Code == "NotImplemented" {
+		// backends without versioning have no versions
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not get versioning of bucket %s: %s", bucket, err)
+	}
+	return len(conf.Status) > 0, nil
+}
+
+// listObjectVersions lists all versions and delete markers below a prefix
+func (d *S3fsDriver) listObjectVersions(bucket string, prefix string) ([]objectVersion, error) {
+	var versions []objectVersion
+	params := url.Values{}
+	params.Set("versions", "")
+	if len(prefix) > 0 {
+		params.Set("prefix", prefix)
+	}
+	for {
+		u, err := d.s3client.Presign(http.MethodGet, bucket, "", presignExpiry, params)
+		if err != nil {
+			return nil, fmt.Errorf("could not presign version listing: %s", err)
+		}
+		resp, err := d.httpClient.Get(u.String())
+		if err != nil {
+			return nil, fmt.Errorf("could not list object versions: %s", err)
+		}
+		result := listVersionsResult{}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("could not list object versions: %s", resp.Status)
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode object versions: %s", err)
+		}
+		versions = append(versions, result.Versions...)
+		for _, m := range result.DeleteMarkers {
+			m.DeleteMarker = true
+			versions = append(versions, m)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		params.Set("key-marker", result.NextKeyMarker)
+		params.Set("version-id-marker", result.NextVersionIDMarker)
+	}
+	return versions, nil
+}
+
+// deleteObject is an object version of a multi-object delete request
+type deleteObject struct {
+	Key       string
+	VersionID string `xml:"VersionId,omitempty"`
+}
+
+// deleteRequest is the body of a multi-object delete request
+type deleteRequest struct {
+	XMLName xml.Name       `xml:"Delete"`
+	Quiet   bool           `xml:"Quiet"`
+	Objects []deleteObject `xml:"Object"`
+}
+
+// deleteResult is the response of a quiet multi-object delete request
+type deleteResult struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	Errors  []struct {
+		Key       string
+		VersionID string `xml:"VersionId"`
+		Code      string
+		Message   string
+	} `xml:"Error"`
+}
+
+// removeObjectVersions removes object versions and delete markers with
+// multi-object delete requests and returns the errors of the versions that
+// could not be removed
+func (d *S3fsDriver) removeObjectVersions(bucket string, versions []objectVersion) []string {
+	var errs []string
+	for start := 0; start < len(versions); start += maxDeleteObjects {
+		end := start + maxDeleteObjects
+		if end > len(versions) {
+			end = len(versions)
+		}
+		batch := deleteRequest{Quiet: true}
+		for _, v := range versions[start:end] {
+			batch.Objects = append(batch.Objects, deleteObject{Key: v.Key, VersionID: v.VersionID})
+		}
+		err := d.deleteObjects(bucket, batch, &errs)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("could not remove %d object versions: %s", end-start, err))
+		}
+	}
+	return errs
+}
+
+// deleteObjects sends a multi-object delete request and appends the errors of
+// the objects that could not be removed
+func (d *S3fsDriver) deleteObjects(bucket string, batch deleteRequest, errs *[]string) error {
+	body, err := xml.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("delete", "")
+	u, err := d.s3client.Presign(http.MethodPost, bucket, "", presignExpiry, params)
+	if err != nil {
+		return fmt.Errorf("could not presign delete: %s", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(body)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	result := deleteResult{}
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("could not decode delete result: %s", err)
+	}
+	for _, e := range result.Errors {
+		*errs = append(*errs, fmt.Sprintf("%s (version %s): %s: %s", e.Key, e.VersionID, e.Code, e.Message))
+	}
+	return nil
+}
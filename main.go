@@ -4,18 +4,13 @@ import (
 	"os"
 
 	dockerVolumeS3 "github.com/AVENTER-UG/docker-volume-s3/lib"
-	util "github.com/AVENTER-UG/util/util"
-	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/sirupsen/logrus"
 )
 
 const socketAddress = "/run/docker/plugins/s3.sock"
 
 func main() {
-	dockerVolumeS3Version := os.Getenv("PLUGIN_VERSION")
-
 	logLevel := os.Getenv("LOG_LEVEL")
-	socketAddress := util.Getenv("S3_CONF_SOCKET", "/run/docker/plugins/s3.sock")
 
 	switch logLevel {
 	case "3":
@@ -31,13 +26,30 @@ func main() {
 		logrus.SetLevel(logrus.ErrorLevel)
 	}
 
-	volDriver, err := dockerVolumeS3.NewDriver()
+	name := "serve"
+	var args []string
+	if len(os.Args) > 1 {
+		name = os.Args[1]
+		args = os.Args[2:]
+	}
+	run, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	// the doctor creates its own driver to diagnose why it fails
+	var volDriver *dockerVolumeS3.S3fsDriver
+	var err error
+	if name != "doctor" {
+		volDriver, err = dockerVolumeS3.NewDriver()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	err = run(volDriver, args)
 	if err != nil {
 		logrus.Fatal(err)
 	}
-
-	h := volume.NewHandler(volDriver)
-	logrus.Infof("plugin(s3) version(%s) started with log level(%s) attending socket(%s)", dockerVolumeS3Version, logLevel, socketAddress)
-	logrus.Error(h.ServeUnix(socketAddress, 0))
-	logrus.Error(h.ServeUnix(socketAddress, 0))
 }